@@ -21,3 +21,19 @@ type VolumeDriver interface {
 	UnmountVolume(string) error
 	GetVolume(string) (map[string]interface{}, error)
 }
+
+// AttachedVolumesLister interface used by the refcountedVolume module to
+// find volumes attached to the VM but not mounted, and detach them.
+type AttachedVolumesLister interface {
+	ListAttachedVolumes() ([]string, error)
+	DetachVolume(string) error
+}
+
+// PoolVolumeResolver interface used by the refcountedVolume module to tell
+// volumes kept in a pool volume. Such volumes are never mounted on their
+// own, their mounts are counted for the pool volume holding them.
+type PoolVolumeResolver interface {
+	// GetPool returns the full name of the pool volume holding the volume,
+	// or "" if the volume isn't in a pool
+	GetPool(string) string
+}
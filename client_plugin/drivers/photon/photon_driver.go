@@ -133,7 +133,7 @@ func (d *VolumeDriver) decrRefCount(vol string) (uint, error) {
 	if d.refCounts.IsInitialized() != true {
 		return 1, nil
 	}
-	return d.refCounts.Decr(vol)
+	return d.refCounts.Decr(log.NewEntry(log.StandardLogger()), vol)
 }
 
 func (d *VolumeDriver) getMountPoint(volName string) string {
@@ -428,12 +428,24 @@ func (d *VolumeDriver) UnmountVolume(name string) error {
 	}
 	id := status["ID"].(string)
 
-	err = fs.Unmount(mountpoint)
-	if err != nil {
+	mount, others, err := plugin_utils.GetVolumeMounts(name, d.mountRoot)
+	if len(others) > 0 {
+		// the device can't be detached while the filesystem is mounted elsewhere
+		log.WithFields(
+			log.Fields{"mountpoint": mountpoint, "others": others},
+		).Error("Volume filesystem is also mounted elsewhere, skipping unmount and detach ")
+		return fmt.Errorf("Volume %s is also mounted at %v", name, others)
+	}
+	if err == nil && mount == nil {
+		log.WithFields(
+			log.Fields{"mountpoint": mountpoint},
+		).Warning("Volume is not mounted, skipping unmount. Now trying to detach... ")
+	} else if err = fs.SafeUnmount(log.NewEntry(log.StandardLogger()), mountpoint, fs.DefaultUnmountPolicy()); err != nil {
+		// detaching would pull the disk from under open files
 		log.WithFields(
 			log.Fields{"mountpoint": mountpoint, "error": err},
-		).Error("Failed to unmount volume. Now trying to detach... ")
-		// Do not return error. Continue with detach.
+		).Error("Failed to unmount volume, skipping detach ")
+		return err
 	}
 	log.WithFields(log.Fields{"name": name, "id": id}).Info("Unmounted volume ")
 
@@ -545,7 +557,7 @@ func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
 	// Cannot remove volumes till plugin completely initializes (refcounting is complete)
 	// because we don't know if it is being used or not
 	if d.refCounts.IsInitialized() != true {
-		msg := fmt.Sprintf(plugin_utils.PluginInitError+" Cannot remove volume=%s", r.Name)
+		msg := fmt.Sprintf(d.refCounts.NotReadyMsg()+" Cannot remove volume=%s", r.Name)
 		log.Error(msg)
 		return volume.Response{Err: msg}
 	}
@@ -586,6 +598,11 @@ func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
 	return volume.Response{Err: ""}
 }
 
+// Status - report plugin health, see refcount.PluginState
+func (d *VolumeDriver) Status() map[string]interface{} {
+	return d.refCounts.GetStatus()
+}
+
 // Capabilities - Report plugin scope to Docker
 func (d *VolumeDriver) Capabilities(r volume.Request) volume.Response {
 	return volume.Response{Capabilities: volume.Capability{Scope: "global"}}
@@ -628,6 +645,8 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 		// no refcounting, no unmount. All unmounts are delayed
 		// until we succesfully populate the refcount map
 		d.refCounts.MarkDirty()
+		log.WithFields(log.Fields{"name": r.Name, "state": d.refCounts.GetState()}).Warning(
+			"Refcounts not available, deferring unmount to refcount recovery ")
 		return volume.Response{Err: ""}
 	}
 
@@ -28,7 +28,6 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
-	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/refcount"
 )
 
@@ -88,7 +87,7 @@ func (d *VolumeDriver) decrRefCount(vol string) (uint, error) {
 	if d.refCounts.IsInitialized() != true {
 		return 1, nil
 	}
-	return d.refCounts.Decr(vol)
+	return d.refCounts.Decr(log.NewEntry(log.StandardLogger()), vol)
 }
 
 // Returns the given volume mountpoint
@@ -142,7 +141,7 @@ func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
 	// Cannot remove volumes till plugin completely initializes (refcounting is complete)
 	// because we don't know if it is being used or not
 	if d.refCounts.IsInitialized() != true {
-		msg := fmt.Sprintf(plugin_utils.PluginInitError+" Cannot remove volume=%s", r.Name)
+		msg := fmt.Sprintf(d.refCounts.NotReadyMsg()+" Cannot remove volume=%s", r.Name)
 		log.Error(msg)
 		return volume.Response{Err: msg}
 	}
@@ -196,6 +195,8 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 		// no refcounting, no unmount. All unmounts are delayed
 		// until we succesfully populate the refcount map
 		d.refCounts.MarkDirty()
+		log.WithFields(log.Fields{"name": r.Name, "state": d.refCounts.GetState()}).Warning(
+			"Refcounts not available, deferring unmount to refcount recovery ")
 		return volume.Response{Err: ""}
 	}
 
@@ -203,6 +204,11 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 	return volume.Response{Err: ""}
 }
 
+// Status - report plugin health, see refcount.PluginState
+func (d *VolumeDriver) Status() map[string]interface{} {
+	return d.refCounts.GetStatus()
+}
+
 // Capabilities - Report plugin scope to Docker
 func (d *VolumeDriver) Capabilities(r volume.Request) volume.Response {
 	return volume.Response{Capabilities: volume.Capability{Scope: "global"}}
@@ -0,0 +1,197 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Operations of the plugin admin interface, see plugin_server.AdminServer.
+// They serve to inspect and fix up refcounts and mounts by hand, e.g. when
+// Docker lost track of a container.
+//
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// RefCountState returns plugin health, refcounts of volumes, the volumes
+// of the mounts Docker asked for and the pools of pool volumes
+func (d *VolumeDriver) RefCountState() map[string]interface{} {
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	mountIDs := make(map[string]string, len(d.mountIDtoName))
+	for id, name := range d.mountIDtoName {
+		mountIDs[id] = name
+	}
+	// pool volumes are counted, but mounted with their pool
+	d.poolMtx.Lock()
+	poolVolumes := make(map[string]string, len(d.poolVolumes))
+	for name, pv := range d.poolVolumes {
+		poolVolumes[name] = pv.Pool
+	}
+	d.poolMtx.Unlock()
+
+	return map[string]interface{}{
+		"Status":      d.refCounts.GetStatus(),
+		"RefCounts":   d.refCounts.GetRefCounts(),
+		"MountIDs":    mountIDs,
+		"PoolVolumes": poolVolumes,
+	}
+}
+
+// VolumeState returns the refcount, mount and attach state of a volume
+// on this VM
+func (d *VolumeDriver) VolumeState(name string) (map[string]interface{}, error) {
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	if err != nil {
+		return nil, err
+	}
+	name = volumeInfo.VolumeName
+
+	mountIDs := []string{}
+	for id, volName := range d.mountIDtoName {
+		if volName == name {
+			mountIDs = append(mountIDs, id)
+		}
+	}
+	state := map[string]interface{}{
+		"Name":       name,
+		"RefCount":   d.refCounts.GetCount(name),
+		"MountIDs":   mountIDs,
+		"Mountpoint": getMountPoint(name),
+	}
+
+	// a pool volume is mounted and attached with its pool
+	if pv := d.getPoolVolume(name); pv != nil {
+		state["Pool"] = pv.Pool
+		state["Mountpoint"] = getPoolVolumeMountPoint(pv)
+		return state, nil
+	}
+
+	mount, others, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if err != nil {
+		return nil, err
+	}
+	state["Mounted"] = mount != nil
+	if mount != nil {
+		state["Device"] = mount.Source
+		state["Options"] = mount.Options
+		state["OtherMounts"] = others
+	}
+
+	volDev := d.getAttachedDevSpec(name)
+	state["Attached"] = volDev != nil
+	if volDev != nil {
+		if device, err := fs.GetDevicePath(volDev); err == nil {
+			state["AttachedDevice"] = device
+		}
+	}
+
+	d.freezes.mtx.Lock()
+	if fv, exists := d.freezes.volumes[name]; exists {
+		state["FrozenUntil"] = fv.until.Format(time.RFC3339)
+	}
+	d.freezes.mtx.Unlock()
+	return state, nil
+}
+
+// ForceUnmount drops the refcount of a volume, even if Docker still uses
+// it, then unmounts and detaches the volume. A busy filesystem is force
+// unmounted, the volume is still not detached if its filesystem stays busy.
+func (d *VolumeDriver) ForceUnmount(name string) error {
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	if err != nil {
+		return err
+	}
+	name = volumeInfo.VolumeName
+	if pv := d.getPoolVolume(name); pv != nil {
+		return fmt.Errorf("Volume %s is kept in pool %s, unmount the pool instead", name, pv.Pool)
+	}
+
+	refcnt := d.refCounts.Clear(name)
+	for id, volName := range d.mountIDtoName {
+		if volName == name {
+			delete(d.mountIDtoName, id)
+		}
+	}
+	d.log.WithFields(log.Fields{"name": name, "refcount": refcnt}).Warning("Force unmounting volume on request ")
+
+	policy := d.unmountPolicy
+	policy.Force = true
+	if err = d.unmountVolume(name, policy); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to force unmount ")
+		return err
+	}
+	return nil
+}
+
+// ForceDetach detaches a volume which is attached to this VM, but neither
+// mounted nor used by containers
+func (d *VolumeDriver) ForceDetach(name string) error {
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	if err != nil {
+		return err
+	}
+	name = volumeInfo.VolumeName
+
+	if refcnt := d.refCounts.GetCount(name); refcnt > 0 {
+		return fmt.Errorf("Volume %s is used by %d container(s), unmount it first", name, refcnt)
+	}
+	mount, _, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if err != nil {
+		return err
+	}
+	if mount != nil {
+		return fmt.Errorf("Volume %s is mounted at %s, unmount it first", name, mount.MountPoint)
+	}
+
+	d.log.WithFields(log.Fields{"name": name}).Warning("Force detaching volume on request ")
+	if err = d.DetachVolume(name); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to force detach ")
+		return err
+	}
+	return nil
+}
+
+// Reconcile rediscovers refcounts from Docker and syncs mounts with them.
+// Mount IDs of volumes no longer used are dropped, the volume of any other
+// mount ID is looked up on unmount.
+func (d *VolumeDriver) Reconcile() error {
+	if err := d.refCounts.Reconcile(d); err != nil {
+		return err
+	}
+
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+	for id, volName := range d.mountIDtoName {
+		if d.refCounts.GetCount(volName) == 0 {
+			delete(d.mountIDtoName, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Create options from the plugin configuration:
+//  CreateOptions - options of volumes created without them
+//  Classes       - named sets of options, selected with -o class=<name>
+//
+// Options given at create take precedence over the class, and the class
+// over CreateOptions. The class and the effective options are recorded in
+// volume metadata. Clones keep the options of their source. mkfs-options
+// can't be given at create, only by a class or CreateOptions.
+//
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
+)
+
+const (
+	// mkfsOptionsOption - options of the mkfs command, e.g. "-i 8192"
+	mkfsOptionsOption = "mkfs-options"
+)
+
+// createClasses - default create options and classes, replaced on reload
+type createClasses struct {
+	mtx      sync.Mutex
+	defaults map[string]string
+	classes  map[string]map[string]string
+}
+
+func newCreateClasses(cfg config.Config) *createClasses {
+	c := &createClasses{}
+	c.set(cfg)
+	return c
+}
+
+// set takes the create options and classes of cfg
+func (c *createClasses) set(cfg config.Config) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.defaults = cfg.CreateOptions
+	c.classes = cfg.Classes
+}
+
+// names returns the sorted class names
+func (c *createClasses) names() []string {
+	var names []string
+	for name := range c.classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// prepareClassOptions adds the options of the class selected in create
+// request r, and the default create options, which r doesn't give
+func (d *VolumeDriver) prepareClassOptions(r volume.Request) error {
+	// mkfs options are passed to mkfs as they are, only the plugin
+	// configuration may give them
+	if _, exists := r.Options[mkfsOptionsOption]; exists {
+		return fmt.Errorf("Option %s can only be set by a class or the default create options",
+			mkfsOptionsOption)
+	}
+
+	c := d.createClasses
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	className, classRes := r.Options[config.ClassOption]
+	if _, cloneFromRes := r.Options["clone-from"]; cloneFromRes {
+		if classRes {
+			return fmt.Errorf("Cannot define the class for a clone")
+		}
+		return nil
+	}
+
+	layers := []map[string]string{c.defaults}
+	if classRes {
+		class, found := c.classes[className]
+		if !found {
+			return fmt.Errorf("Invalid %s option %s, valid classes are: %s",
+				config.ClassOption, className, strings.Join(c.names(), ", "))
+		}
+		layers = append([]map[string]string{class}, layers...)
+	}
+	for _, options := range layers {
+		for option, value := range options {
+			if _, exists := r.Options[option]; !exists {
+				r.Options[option] = value
+			}
+		}
+	}
+
+	d.log.WithFields(log.Fields{"name": r.Name, "class": className,
+		"options": r.Options}).Debug("Effective create options ")
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+import (
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/reqid"
+)
+
+func classesDriver() *VolumeDriver {
+	cfg := config.Config{
+		CreateOptions: map[string]string{"size": "10gb", "diskformat": "thin"},
+		Classes: map[string]map[string]string{
+			"gold":        {"size": "50gb", "fstype": "xfs"},
+			"small-files": {mkfsOptionsOption: "-i 8192"},
+		},
+	}
+	return &VolumeDriver{driverState: &driverState{createClasses: newCreateClasses(cfg)}, log: reqid.Log("")}
+}
+
+func TestClassOptionsPrecedence(t *testing.T) {
+	d := classesDriver()
+
+	r := volume.Request{Name: "vol1", Options: map[string]string{}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	assert.Equal(t, map[string]string{"size": "10gb", "diskformat": "thin"}, r.Options)
+
+	r = volume.Request{Name: "vol2", Options: map[string]string{config.ClassOption: "gold"}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	assert.Equal(t, "50gb", r.Options["size"], "class over defaults")
+	assert.Equal(t, "xfs", r.Options["fstype"])
+	assert.Equal(t, "thin", r.Options["diskformat"])
+
+	r = volume.Request{Name: "vol3", Options: map[string]string{config.ClassOption: "gold", "size": "20gb"}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	assert.Equal(t, "20gb", r.Options["size"], "create options over class")
+
+	r = volume.Request{Name: "vol4", Options: map[string]string{config.ClassOption: "small-files"}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	assert.Equal(t, "-i 8192", r.Options[mkfsOptionsOption])
+
+	r = volume.Request{Name: "vol5", Options: map[string]string{config.ClassOption: "silver"}}
+	assert.NotNil(t, d.prepareClassOptions(r), "unknown class")
+}
+
+func TestClassOptionsRejected(t *testing.T) {
+	d := classesDriver()
+
+	r := volume.Request{Name: "vol1", Options: map[string]string{mkfsOptionsOption: "-O ^has_journal"}}
+	assert.NotNil(t, d.prepareClassOptions(r), "mkfs options at create")
+
+	r = volume.Request{Name: "vol2", Options: map[string]string{"clone-from": "vol1", config.ClassOption: "gold"}}
+	assert.NotNil(t, d.prepareClassOptions(r), "class of a clone")
+
+	r = volume.Request{Name: "vol3", Options: map[string]string{"clone-from": "vol1"}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	assert.Equal(t, map[string]string{"clone-from": "vol1"}, r.Options, "no defaults for clones")
+}
+
+// entryHook keeps the entries logged at debug level
+type entryHook struct {
+	entries []*log.Entry
+}
+
+func (h *entryHook) Levels() []log.Level {
+	return []log.Level{log.DebugLevel}
+}
+
+func (h *entryHook) Fire(entry *log.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func TestRequestIDLogged(t *testing.T) {
+	logger := log.StandardLogger()
+	defer func(level log.Level, hooks log.LevelHooks) {
+		logger.Level = level
+		logger.Hooks = hooks
+	}(logger.Level, logger.Hooks)
+	hook := &entryHook{}
+	logger.Level = log.DebugLevel
+	logger.Hooks = make(log.LevelHooks)
+	logger.Hooks.Add(hook)
+
+	d := classesDriver().WithRequestID("1a2b").(*VolumeDriver)
+	r := volume.Request{Name: "vol1", Options: map[string]string{}}
+	assert.Nil(t, d.prepareClassOptions(r))
+	if assert.Len(t, hook.entries, 1) {
+		assert.Equal(t, "1a2b", hook.entries[0].Data[reqid.Key])
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Encryption of volumes in the guest, set with the encrypt volume option:
+//  none - not encrypted (default)
+//  luks - the disk holds a LUKS container, formatted on create and opened
+//         on mount with the key from the configured key provider
+//
+// The cipher and the key ID (encrypt-cipher, encrypt-key-id options) are
+// recorded in volume metadata, the key never leaves the Docker host.
+//
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/keyprovider"
+)
+
+const (
+	encryptOption       = "encrypt"
+	encryptCipherOption = "encrypt-cipher"
+	encryptKeyIDOption  = "encrypt-key-id"
+	encryptNone         = "none"
+	encryptLuks         = "luks"
+	defaultKeyID        = "default"
+)
+
+// encryptor - encryption settings, and key IDs of mounted volumes
+type encryptor struct {
+	keys   keyprovider.Provider // nil if no key provider is configured
+	keyID  string               // key ID of volumes created without one
+	cipher string               // cipher of volumes created without one
+	mtx    sync.Mutex           // protects keyIDs
+	keyIDs map[string]string    // volume name -> key ID, "" if not encrypted
+}
+
+func newEncryptor(cfg config.Config) *encryptor {
+	e := &encryptor{
+		keyID:  cfg.LuksKeyID,
+		cipher: cfg.LuksCipher,
+		keyIDs: make(map[string]string),
+	}
+	if e.keyID == "" {
+		e.keyID = defaultKeyID
+	}
+	if e.cipher == "" {
+		e.cipher = fs.LuksCipherDefault
+	}
+	keys, err := keyprovider.NewProvider(cfg.LuksKeyFile, cfg.LuksKeyCommand)
+	if err != nil {
+		log.WithFields(log.Fields{"error": err}).Error("Invalid key provider, encrypted volumes can't be used ")
+	}
+	e.keys = keys
+	return e
+}
+
+// getKey returns the key with keyID from the key provider
+func (e *encryptor) getKey(keyID string) ([]byte, error) {
+	if e.keys == nil {
+		return nil, fmt.Errorf("No key provider configured for encrypted volumes")
+	}
+	return e.keys.GetKey(keyID)
+}
+
+// prepareEncryptOptions validates the encryption options of create request
+// r, and sets the default cipher and key ID for encrypted volumes
+func (d *VolumeDriver) prepareEncryptOptions(r volume.Request) error {
+	encrypt, exists := r.Options[encryptOption]
+	if !exists || encrypt == encryptNone {
+		return nil
+	}
+	if encrypt != encryptLuks {
+		return fmt.Errorf("Invalid %s option %s, valid options are %s and %s",
+			encryptOption, encrypt, encryptNone, encryptLuks)
+	}
+	if _, cloneFromRes := r.Options["clone-from"]; cloneFromRes {
+		return fmt.Errorf("Cannot define the encryption for a clone")
+	}
+	if _, exists = r.Options[encryptCipherOption]; !exists {
+		r.Options[encryptCipherOption] = d.encryption.cipher
+	}
+	if _, exists = r.Options[encryptKeyIDOption]; !exists {
+		r.Options[encryptKeyIDOption] = d.encryption.keyID
+	}
+	// fail before the volume is created if the key isn't available
+	_, err := d.encryption.getKey(r.Options[encryptKeyIDOption])
+	return err
+}
+
+// setEncryption remembers the key ID of a volume from its metadata
+func (d *VolumeDriver) setEncryption(name string, meta map[string]interface{}) {
+	keyID := ""
+	if encrypt, _ := meta[encryptOption].(string); encrypt == encryptLuks {
+		keyID, _ = meta[encryptKeyIDOption].(string)
+		if keyID == "" {
+			keyID = d.encryption.keyID
+		}
+	}
+	d.encryption.mtx.Lock()
+	defer d.encryption.mtx.Unlock()
+	d.encryption.keyIDs[name] = keyID
+}
+
+// getKeyID returns the key ID of an encrypted volume, or "" if the volume
+// isn't encrypted
+func (d *VolumeDriver) getKeyID(name string) (string, error) {
+	d.encryption.mtx.Lock()
+	keyID, exists := d.encryption.keyIDs[name]
+	d.encryption.mtx.Unlock()
+	if exists {
+		return keyID, nil
+	}
+
+	// mounted before the plugin started, e.g. on a recovery mount
+	meta, err := d.ops.Get(name)
+	if err != nil {
+		return "", err
+	}
+	d.setEncryption(name, meta)
+	return d.getKeyID(name)
+}
+
+// formatLuks creates a LUKS container on the device of a new volume and
+// opens it, returning the mapped device for the filesystem
+func (d *VolumeDriver) formatLuks(r volume.Request, device string) (string, error) {
+	key, err := d.encryption.getKey(r.Options[encryptKeyIDOption])
+	if err != nil {
+		return "", err
+	}
+	if err = fs.LuksFormat(d.log, device, r.Options[encryptCipherOption], key); err != nil {
+		return "", err
+	}
+	return fs.LuksOpen(device, fs.LuksMapName(r.Name), key, false)
+}
+
+// openLuks opens the LUKS container of an encrypted volume and returns the
+// mapped device, or returns device if the volume isn't encrypted
+func (d *VolumeDriver) openLuks(name string, device string) (string, error) {
+	keyID, err := d.getKeyID(name)
+	if err != nil || keyID == "" {
+		return device, err
+	}
+	if !fs.IsLuks(device) {
+		return "", fmt.Errorf("Volume %s is encrypted, but device %s holds no LUKS container", name, device)
+	}
+	key, err := d.encryption.getKey(keyID)
+	if err != nil {
+		return "", err
+	}
+	// trims of the filesystem are passed to the disk only if asked for
+	allowDiscards := d.getTrimMode(name) != trimOff
+	return fs.LuksOpen(device, fs.LuksMapName(name), key, allowDiscards)
+}
+
+// closeLuks closes the LUKS mapping of a volume, if open, and returns the
+// disk device under it, or "" if there was no mapping
+func (d *VolumeDriver) closeLuks(name string) (string, error) {
+	mapName := fs.LuksMapName(name)
+	if !fs.LuksIsOpen(mapName) {
+		return "", nil
+	}
+	device, err := fs.GetLuksDevice(mapName)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to find device of LUKS mapping ")
+	}
+	if err = fs.LuksClose(mapName); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to close LUKS mapping ")
+		return "", err
+	}
+	return device, nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Filesystem freeze of mounted volumes, requested on the admin interface,
+// e.g. around a snapshot of the disk taken outside of the plugin.
+//
+// A freeze always carries a timeout, the volume is thawed when it expires
+// so a lost thaw request can't block writes of containers for good. Only
+// volumes mounted by this plugin for running containers can be frozen.
+//
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// maxFreezeTimeout - longest a volume may stay frozen
+const maxFreezeTimeout = 15 * time.Minute
+
+// thawRetryInterval - wait before an auto-thaw is retried after a failed thaw
+var thawRetryInterval = 10 * time.Second
+
+// thawFilesystem thaws the filesystem at a mount point, replaced in tests
+var thawFilesystem = fs.Thaw
+
+// frozenVolume - a frozen volume and its auto-thaw timer
+type frozenVolume struct {
+	until time.Time
+	timer *time.Timer
+}
+
+// freezer - frozen volumes
+type freezer struct {
+	mtx     sync.Mutex               // protects volumes
+	volumes map[string]*frozenVolume // volume name -> freeze
+}
+
+func newFreezer() *freezer {
+	return &freezer{volumes: make(map[string]*frozenVolume)}
+}
+
+// status adds the auto-thaw time of a frozen volume to its status
+func (f *freezer) status(name string, status map[string]interface{}) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if fv, exists := f.volumes[name]; exists {
+		status["frozen-until"] = fv.until.Format(time.RFC3339)
+	}
+}
+
+// Freeze freezes the filesystem of a volume mounted by the plugin, the
+// volume is thawed after timeout unless thawed earlier
+func (d *VolumeDriver) Freeze(name string, timeout time.Duration) error {
+	if timeout <= 0 || timeout > maxFreezeTimeout {
+		return fmt.Errorf("Invalid auto-thaw timeout %v, a timeout up to %v is required",
+			timeout, maxFreezeTimeout)
+	}
+
+	// the full name is looked up on ESX before the state is locked
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	if err != nil {
+		return err
+	}
+	name = volumeInfo.VolumeName
+
+	// the freeze lock is taken before the state is unlocked, so an unmount
+	// of the volume waits for the freeze and thaws it
+	d.refCounts.StateMtx.Lock()
+	if err = d.checkFreezeTarget(name); err != nil {
+		d.refCounts.StateMtx.Unlock()
+		return err
+	}
+	d.freezes.mtx.Lock()
+	d.refCounts.StateMtx.Unlock()
+	defer d.freezes.mtx.Unlock()
+
+	if fv, exists := d.freezes.volumes[name]; exists {
+		return fmt.Errorf("Volume %s is already frozen until %s", name, fv.until.Format(time.RFC3339))
+	}
+	if err = fs.Freeze(getMountPoint(name)); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to freeze volume ")
+		return err
+	}
+
+	fv := &frozenVolume{until: time.Now().Add(timeout)}
+	fv.timer = time.AfterFunc(timeout, func() { d.autoThaw(name, fv) })
+	d.freezes.volumes[name] = fv
+	d.log.WithFields(log.Fields{"name": name, "timeout": timeout}).Info("Volume frozen ")
+	return nil
+}
+
+// Thaw thaws a volume frozen with Freeze
+func (d *VolumeDriver) Thaw(name string) error {
+	d.refCounts.StateMtx.Lock()
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	d.refCounts.StateMtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	d.freezes.mtx.Lock()
+	defer d.freezes.mtx.Unlock()
+	if _, exists := d.freezes.volumes[volumeInfo.VolumeName]; !exists {
+		return fmt.Errorf("Volume %s is not frozen", volumeInfo.VolumeName)
+	}
+	return d.thaw(volumeInfo.VolumeName)
+}
+
+// checkFreezeTarget checks if the volume with the full name name can be
+// frozen. Caller must hold d.refCounts.StateMtx.
+func (d *VolumeDriver) checkFreezeTarget(name string) error {
+	if !d.refCounts.IsInitialized() {
+		return fmt.Errorf(d.refCounts.NotReadyMsg()+" Cannot freeze volume=%s", name)
+	}
+	if pv := d.getPoolVolume(name); pv != nil {
+		return fmt.Errorf("Volume %s is kept in pool %s, freeze the pool instead", name, pv.Pool)
+	}
+	if d.refCounts.GetCount(name) == 0 {
+		return fmt.Errorf("Volume %s is not used by any container on this host", name)
+	}
+	mount, _, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if err != nil {
+		return err
+	}
+	if mount == nil {
+		return fmt.Errorf("Volume %s is not mounted by the plugin", name)
+	}
+	return nil
+}
+
+// thaw thaws a frozen volume and forgets the freeze. If the thaw fails
+// the volume stays frozen and the auto-thaw is retried.
+// Caller must hold d.freezes.mtx.
+func (d *VolumeDriver) thaw(name string) error {
+	fv := d.freezes.volumes[name]
+	fv.timer.Stop()
+	if err := thawFilesystem(getMountPoint(name)); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to thaw volume ")
+		fv.until = time.Now().Add(thawRetryInterval)
+		fv.timer.Reset(thawRetryInterval)
+		return err
+	}
+	delete(d.freezes.volumes, name)
+	d.log.WithFields(log.Fields{"name": name}).Info("Volume thawed ")
+	return nil
+}
+
+// autoThaw thaws a volume when its freeze timeout expires
+func (d *VolumeDriver) autoThaw(name string, fv *frozenVolume) {
+	d.freezes.mtx.Lock()
+	defer d.freezes.mtx.Unlock()
+	// the volume may have been thawed, and frozen again, meanwhile
+	if d.freezes.volumes[name] != fv {
+		return
+	}
+	d.log.WithFields(log.Fields{"name": name}).Warning("Freeze timeout expired, thawing volume ")
+	d.thaw(name)
+}
+
+// thawBeforeUnmount thaws a volume which is frozen, as a frozen
+// filesystem can't be unmounted
+func (d *VolumeDriver) thawBeforeUnmount(name string) {
+	d.freezes.mtx.Lock()
+	defer d.freezes.mtx.Unlock()
+	if _, exists := d.freezes.volumes[name]; exists {
+		d.thaw(name)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/reqid"
+)
+
+func TestFreezeTimeout(t *testing.T) {
+	d := &VolumeDriver{driverState: &driverState{freezes: newFreezer()}, log: reqid.Log("")}
+	assert.NotNil(t, d.Freeze("vol@datastore1", 0))
+	assert.NotNil(t, d.Freeze("vol@datastore1", -time.Second))
+	assert.NotNil(t, d.Freeze("vol@datastore1", maxFreezeTimeout+time.Second))
+}
+
+func isFrozen(d *VolumeDriver, name string) bool {
+	d.freezes.mtx.Lock()
+	defer d.freezes.mtx.Unlock()
+	_, exists := d.freezes.volumes[name]
+	return exists
+}
+
+func TestAutoThaw(t *testing.T) {
+	defer func(thaw func(string) error, retry time.Duration) {
+		thawFilesystem = thaw
+		thawRetryInterval = retry
+	}(thawFilesystem, thawRetryInterval)
+
+	// the first thaw fails, the second succeeds
+	thaws := make(chan string, 2)
+	failures := 1
+	thawFilesystem = func(mountpoint string) error {
+		thaws <- mountpoint
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("thaw failed")
+		}
+		return nil
+	}
+	thawRetryInterval = 100 * time.Millisecond
+
+	name := "vol@datastore1"
+	d := &VolumeDriver{driverState: &driverState{freezes: newFreezer()}, log: reqid.Log("")}
+	fv := &frozenVolume{until: time.Now().Add(10 * time.Millisecond)}
+	d.freezes.mtx.Lock()
+	fv.timer = time.AfterFunc(10*time.Millisecond, func() { d.autoThaw(name, fv) })
+	d.freezes.volumes[name] = fv
+	d.freezes.mtx.Unlock()
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		select {
+		case mountpoint := <-thaws:
+			assert.Equal(t, getMountPoint(name), mountpoint)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Volume not thawed, attempt %d", attempt)
+		}
+		assert.Equal(t, attempt == 1, isFrozen(d, name), "attempt %d", attempt)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vmdk
+
+//
+// Readiness checks of the plugin admin interface, see
+// plugin_server.HealthChecker. The plugin is ready when it can serve
+// volumes: ESX and Docker answer, refcounts are known, volumes can be
+// mounted and filesystems created.
+//
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/vmware/docker-volume-vsphere/client_plugin/drivers/vmdk/vmdkops"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/refcount"
+)
+
+// readyCheckTimeout - max time of all readiness checks, requests to ESX
+// queue up behind running ones
+const readyCheckTimeout = 5 * time.Second
+
+// esxCheckMaxAge - the ESX check takes the outcome of a request sent to
+// ESX as recently, instead of queueing a ping behind running requests
+const esxCheckMaxAge = 30 * time.Second
+
+// readyCheck - result of a single readiness check
+type readyCheck struct {
+	name string
+	err  error
+}
+
+// ReadyChecks runs the readiness checks in parallel and returns their results
+func (d *VolumeDriver) ReadyChecks() map[string]error {
+	checks := map[string]func() error{
+		"esx":       d.checkEsx,
+		"docker":    refcount.PingDocker,
+		"refcount":  d.checkRefCounts,
+		"mountroot": checkMountRoot,
+		"mkfs":      func() error { return fs.VerifyFSSupport(fs.FstypeDefault) },
+	}
+
+	// buffered, so checks still running after the timeout don't block
+	results := make(chan readyCheck, len(checks))
+	for name, check := range checks {
+		go func(name string, check func() error) {
+			results <- readyCheck{name: name, err: check()}
+		}(name, check)
+	}
+
+	errs := make(map[string]error, len(checks))
+	timeout := time.After(readyCheckTimeout)
+	for len(errs) < len(checks) {
+		select {
+		case result := <-results:
+			errs[result.name] = result.err
+		case <-timeout:
+			for name := range checks {
+				if _, done := errs[name]; !done {
+					errs[name] = fmt.Errorf("Timed out after %v", readyCheckTimeout)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// checkEsx fails unless ESX answered the last request, or a ping if no
+// request was sent recently
+func (d *VolumeDriver) checkEsx() error {
+	if last, err := vmdkops.LastRoundTrip(); time.Since(last) < esxCheckMaxAge {
+		return err
+	}
+	return d.ops.Ping()
+}
+
+// checkRefCounts fails until refcounts are discovered from Docker
+func (d *VolumeDriver) checkRefCounts() error {
+	if !d.refCounts.IsInitialized() {
+		return fmt.Errorf("Refcounts are %s", d.refCounts.GetState())
+	}
+	return nil
+}
+
+// checkMountRoot fails unless volume mount points can be created
+func checkMountRoot() error {
+	if err := os.MkdirAll(mountRoot, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(mountRoot, ".readyz")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vmdk
+
+//
+// Metrics gauges of the driver, set from the refcounts and mounts
+// of the plugin at scrape time.
+//
+
+import (
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// collectMetrics sets the refcount and mounted volumes gauges
+func (d *VolumeDriver) collectMetrics(driverName string) {
+	metrics.RefCounts.Reset()
+	for vol, count := range d.refCounts.GetCounts() {
+		metrics.RefCounts.Set(float64(count), vol)
+	}
+
+	mounts, err := plugin_utils.GetMountInfo(mountRoot)
+	if err != nil {
+		return
+	}
+	metrics.MountedVolumes.Set(float64(len(mounts)), driverName)
+}
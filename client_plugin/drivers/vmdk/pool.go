@@ -0,0 +1,500 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Pool volumes - small volumes kept as directories of a "pool" volume,
+// created with:
+//   docker volume create --driver=vsphere -o pool=<pool volume> -o size=1GB <name>
+//
+// The pool volume is attached and mounted once while any of its volumes
+// is mounted, and refcounted like any other volume. Space used by a pool
+// volume is limited by a project quota, so the pool filesystem must be
+// XFS or ext4.
+//
+// Pool volumes are recorded in the pool filesystem, and indexed on the
+// Docker host so they are found without mounting the pools. The index is
+// refreshed from the records whenever a pool is mounted for its volumes.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+const (
+	poolOption     = "pool"
+	sizeOption     = "size"
+	poolVolumesDir = "volumes"            // volume directories in the pool
+	poolRecordsDir = ".volumes"           // volume records in the pool
+	poolIndexFile  = ".pool-volumes.json" // index in the mount root
+	firstProjectID = 1000                 // project IDs below are left to admins
+)
+
+// poolVolume - a volume kept in a pool volume
+type poolVolume struct {
+	Name      string // full name, on the datastore of the pool
+	Pool      string // full name of the pool volume
+	Size      string // as requested, e.g. 1GB
+	SizeBytes uint64
+	ProjectID uint32
+	Created   string
+}
+
+// sizeUnits - units accepted in the size option, as by the ESX service
+var sizeUnits = map[string]uint64{
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+	"tb": 1 << 40,
+}
+
+// parseSize returns the number of bytes in size given as <int><unit>, e.g. 10gb
+func parseSize(size string) (uint64, error) {
+	size = strings.ToLower(size)
+	if len(size) > 2 {
+		if unit, exists := sizeUnits[size[len(size)-2:]]; exists {
+			n, err := strconv.ParseUint(size[:len(size)-2], 10, 64)
+			if err == nil && n > 0 {
+				return n * unit, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("Invalid size %s, valid sizes are of form X[mMgGtT]b where X is an integer", size)
+}
+
+// shortName returns the volume name without the datastore
+func shortName(name string) string {
+	return strings.Split(name, "@")[0]
+}
+
+// loadPoolIndex loads the pool volumes indexed on this host
+func (d *VolumeDriver) loadPoolIndex() {
+	d.poolVolumes = make(map[string]*poolVolume)
+	data, err := ioutil.ReadFile(filepath.Join(mountRoot, poolIndexFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.log.WithFields(log.Fields{"error": err}).Warning("Failed to read pool volume index ")
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &d.poolVolumes); err != nil {
+		d.log.WithFields(log.Fields{"error": err}).Warning("Failed to parse pool volume index ")
+		d.poolVolumes = make(map[string]*poolVolume)
+	}
+	for _, pv := range d.poolVolumes {
+		d.pools[pv.Pool] = true
+	}
+}
+
+// savePoolIndex writes the pool volume index. Caller must hold poolMtx.
+func (d *VolumeDriver) savePoolIndex() error {
+	data, err := json.Marshal(d.poolVolumes)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(mountRoot, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(mountRoot, poolIndexFile)
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// getPoolVolume returns the pool volume with name, full or short, or nil
+func (d *VolumeDriver) getPoolVolume(name string) *poolVolume {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	if pv, exists := d.poolVolumes[name]; exists {
+		return pv
+	}
+	if plugin_utils.IsFullVolName(name) {
+		return nil
+	}
+	for fullName, pv := range d.poolVolumes {
+		if shortName(fullName) == name {
+			return pv
+		}
+	}
+	return nil
+}
+
+// GetPool returns the full name of the pool holding the volume, or ""
+func (d *VolumeDriver) GetPool(name string) string {
+	if pv := d.getPoolVolume(name); pv != nil {
+		return pv.Pool
+	}
+	return ""
+}
+
+// isPool checks if the volume, full or short name, holds pool volumes
+func (d *VolumeDriver) isPool(name string) bool {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	for pool := range d.pools {
+		if pool == name || (!plugin_utils.IsFullVolName(name) && shortName(pool) == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPoolVolumeMountPoint returns the directory of a pool volume
+func getPoolVolumeMountPoint(pv *poolVolume) string {
+	return filepath.Join(getMountPoint(pv.Pool), poolVolumesDir, pv.Name)
+}
+
+// getPoolRecordPath returns the path of the record of a pool volume
+func getPoolRecordPath(pv *poolVolume) string {
+	return filepath.Join(getMountPoint(pv.Pool), poolRecordsDir, pv.Name+".json")
+}
+
+// getPoolDevice returns the device of a mounted pool
+func getPoolDevice(pool string) (string, error) {
+	mount, _, err := plugin_utils.GetVolumeMounts(pool, mountRoot)
+	if err != nil {
+		return "", err
+	}
+	if mount == nil {
+		return "", fmt.Errorf("Pool %s is not mounted", pool)
+	}
+	return mount.Source, nil
+}
+
+// acquirePool mounts the pool for use by its volumes, or counts one more
+// use if it is mounted. Caller must hold refCounts.StateMtx.
+func (d *VolumeDriver) acquirePool(pool string) error {
+	d.poolMtx.Lock()
+	d.pools[pool] = true
+	d.poolMtx.Unlock()
+
+	refcnt := d.incrRefCount(pool)
+	if refcnt > 1 || plugin_utils.AlreadyMounted(pool, mountRoot) {
+		d.loadPoolRecords(pool)
+		return nil
+	}
+
+	meta, err := d.ops.Get(pool)
+	if err == nil {
+		err = checkPoolMeta(pool, meta)
+	}
+	if err == nil {
+		_, err = d.MountVolume(pool, meta["fstype"].(string), "", false, false)
+	}
+	if err != nil {
+		d.log.WithFields(log.Fields{"pool": pool, "error": err}).Error("Failed to mount pool ")
+		if refcnt, _ := d.decrRefCount(pool); refcnt == 0 {
+			d.detach(pool)
+		}
+		return err
+	}
+	d.loadPoolRecords(pool)
+	return nil
+}
+
+// releasePool drops a use of the pool by its volumes, and unmounts it when
+// it is not used anymore. Caller must hold refCounts.StateMtx.
+func (d *VolumeDriver) releasePool(pool string) {
+	refcnt, err := d.decrRefCount(pool)
+	if err != nil || refcnt > 0 {
+		return
+	}
+	if err = d.UnmountVolume(pool); err != nil {
+		d.log.WithFields(log.Fields{"pool": pool, "error": err}).Error("Failed to unmount pool ")
+	}
+}
+
+// checkPoolMeta checks if a volume can be used as a pool
+func checkPoolMeta(pool string, meta map[string]interface{}) error {
+	fstype, _ := meta["fstype"].(string)
+	if fstype != "xfs" && fstype != "ext4" {
+		return fmt.Errorf("Pool %s has %s filesystem, pools need xfs or ext4", pool, fstype)
+	}
+	if access, _ := meta["access"].(string); access == "read-only" {
+		return fmt.Errorf("Pool %s is read-only", pool)
+	}
+	return nil
+}
+
+// loadPoolRecords refreshes the index from the records in a mounted pool
+func (d *VolumeDriver) loadPoolRecords(pool string) {
+	files, err := filepath.Glob(filepath.Join(getMountPoint(pool), poolRecordsDir, "*.json"))
+	if err != nil {
+		return
+	}
+	records := make(map[string]*poolVolume)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		pv := &poolVolume{}
+		if err = json.Unmarshal(data, pv); err != nil || pv.Name == "" {
+			d.log.WithFields(log.Fields{"record": file, "error": err}).Warning("Invalid pool volume record ")
+			continue
+		}
+		pv.Pool = pool
+		records[pv.Name] = pv
+	}
+
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	for name, pv := range d.poolVolumes {
+		if pv.Pool == pool && records[name] == nil {
+			delete(d.poolVolumes, name)
+		}
+	}
+	for name, pv := range records {
+		d.poolVolumes[name] = pv
+	}
+	if err = d.savePoolIndex(); err != nil {
+		d.log.WithFields(log.Fields{"pool": pool, "error": err}).Warning("Failed to save pool volume index ")
+	}
+}
+
+// nextProjectID returns a project ID not used in the pool
+func (d *VolumeDriver) nextProjectID(pool string) uint32 {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	id := uint32(firstProjectID)
+	for _, pv := range d.poolVolumes {
+		if pv.Pool == pool && pv.ProjectID >= id {
+			id = pv.ProjectID + 1
+		}
+	}
+	return id
+}
+
+// createPoolVolume creates a volume in a pool
+func (d *VolumeDriver) createPoolVolume(r volume.Request) volume.Response {
+	for option := range r.Options {
+		if option != poolOption && option != sizeOption {
+			msg := fmt.Sprintf("Option %s is not supported for pool volumes", option)
+			d.log.WithFields(log.Fields{"name": r.Name}).Error(msg)
+			return volume.Response{Err: msg}
+		}
+	}
+	size, err := parseSize(r.Options[sizeOption])
+	if err != nil {
+		return volume.Response{Err: err.Error()}
+	}
+
+	poolInfo, err := plugin_utils.GetVolumeInfo(r.Options[poolOption], "", d)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": r.Name, "error": err}).Error("Failed to find pool ")
+		return volume.Response{Err: err.Error()}
+	}
+	pool := poolInfo.VolumeName
+	if d.getPoolVolume(pool) != nil {
+		return volume.Response{Err: fmt.Sprintf("Pool volume %s can't hold volumes", pool)}
+	}
+	name := r.Name
+	if !plugin_utils.IsFullVolName(name) {
+		name = shortName(name) + "@" + pool[strings.Index(pool, "@")+1:]
+	}
+	if pv := d.getPoolVolume(name); pv != nil {
+		if pv.Pool != pool {
+			return volume.Response{Err: fmt.Sprintf("Volume %s already exists in pool %s", name, pv.Pool)}
+		}
+		return volume.Response{Err: ""}
+	}
+	if _, err = d.ops.Get(name); err == nil {
+		return volume.Response{Err: fmt.Sprintf("Volume %s already exists", name)}
+	}
+
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	if err = d.acquirePool(pool); err != nil {
+		return volume.Response{Err: err.Error()}
+	}
+	defer d.releasePool(pool)
+
+	// recorded in the pool, but missing in the index of this host
+	if d.getPoolVolume(name) != nil {
+		return volume.Response{Err: ""}
+	}
+
+	pv := &poolVolume{
+		Name:      name,
+		Pool:      pool,
+		Size:      r.Options[sizeOption],
+		SizeBytes: size,
+		ProjectID: d.nextProjectID(pool),
+		Created:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if err = d.makePoolVolume(pv); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "pool": pool, "error": err}).Error("Failed to create pool volume ")
+		os.RemoveAll(getPoolVolumeMountPoint(pv))
+		os.Remove(getPoolRecordPath(pv))
+		return volume.Response{Err: err.Error()}
+	}
+
+	d.poolMtx.Lock()
+	d.poolVolumes[name] = pv
+	err = d.savePoolIndex()
+	d.poolMtx.Unlock()
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to save pool volume index ")
+	}
+
+	d.log.WithFields(log.Fields{"name": name, "pool": pool, "size": pv.Size,
+		"project": pv.ProjectID}).Info("Pool volume created ")
+	return volume.Response{Err: ""}
+}
+
+// makePoolVolume creates the quota limited directory and the record of a
+// pool volume in the mounted pool
+func (d *VolumeDriver) makePoolVolume(pv *poolVolume) error {
+	device, err := getPoolDevice(pv.Pool)
+	if err != nil {
+		return err
+	}
+	dir := getPoolVolumeMountPoint(pv)
+	if err = os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	if err = fs.SetProjectID(dir, pv.ProjectID); err != nil {
+		return err
+	}
+	if err = fs.SetProjectQuota(d.log, device, pv.ProjectID, pv.SizeBytes); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(pv)
+	if err != nil {
+		return err
+	}
+	record := getPoolRecordPath(pv)
+	if err = os.MkdirAll(filepath.Dir(record), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(record, data, 0644)
+}
+
+// removePoolVolume removes a volume from its pool
+func (d *VolumeDriver) removePoolVolume(pv *poolVolume) volume.Response {
+	d.refCounts.StateMtx.Lock()
+	defer d.refCounts.StateMtx.Unlock()
+
+	if d.getRefCount(pv.Name) != 0 {
+		msg := fmt.Sprintf("Remove failure - volume is still mounted. "+
+			" volume=%s, refcount=%d", pv.Name, d.getRefCount(pv.Name))
+		d.log.Error(msg)
+		return volume.Response{Err: msg}
+	}
+
+	if err := d.acquirePool(pv.Pool); err != nil {
+		return volume.Response{Err: err.Error()}
+	}
+	defer d.releasePool(pv.Pool)
+
+	if err := os.RemoveAll(getPoolVolumeMountPoint(pv)); err != nil {
+		d.log.WithFields(log.Fields{"name": pv.Name, "error": err}).Error("Failed to remove pool volume ")
+		return volume.Response{Err: err.Error()}
+	}
+	if device, err := getPoolDevice(pv.Pool); err == nil {
+		fs.SetProjectQuota(d.log, device, pv.ProjectID, 0)
+	}
+	os.Remove(getPoolRecordPath(pv))
+
+	d.poolMtx.Lock()
+	delete(d.poolVolumes, pv.Name)
+	err := d.savePoolIndex()
+	d.poolMtx.Unlock()
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": pv.Name, "error": err}).Warning("Failed to save pool volume index ")
+	}
+
+	d.log.WithFields(log.Fields{"name": pv.Name, "pool": pv.Pool}).Info("Pool volume removed ")
+	return volume.Response{Err: ""}
+}
+
+// mountPoolVolume mounts the pool of a volume, if it isn't mounted yet,
+// and returns the volume directory. Caller must hold refCounts.StateMtx.
+func (d *VolumeDriver) mountPoolVolume(pv *poolVolume) volume.Response {
+	mountpoint := getPoolVolumeMountPoint(pv)
+	refcnt := d.incrRefCount(pv.Name)
+	if refcnt > 1 {
+		d.log.WithFields(
+			log.Fields{"name": pv.Name, "refcount": refcnt},
+		).Info("Already mounted, skipping mount. ")
+		return volume.Response{Mountpoint: mountpoint}
+	}
+
+	if err := d.acquirePool(pv.Pool); err != nil {
+		d.decrRefCount(pv.Name)
+		return volume.Response{Err: err.Error()}
+	}
+	if _, err := os.Stat(mountpoint); err != nil {
+		d.log.WithFields(log.Fields{"name": pv.Name, "error": err}).Error("Pool volume directory not found ")
+		d.decrRefCount(pv.Name)
+		d.releasePool(pv.Pool)
+		return volume.Response{Err: err.Error()}
+	}
+	return volume.Response{Mountpoint: mountpoint}
+}
+
+// getPoolVolumeStatus returns the status of a pool volume
+func (d *VolumeDriver) getPoolVolumeStatus(pv *poolVolume) map[string]interface{} {
+	status := map[string]interface{}{
+		"datastore":  pv.Name[strings.Index(pv.Name, "@")+1:],
+		"pool":       pv.Pool,
+		"size":       pv.Size,
+		"project-id": pv.ProjectID,
+		"created":    pv.Created,
+	}
+	// usage is known only where the pool is mounted
+	if device, err := getPoolDevice(pv.Pool); err == nil {
+		if used, err := fs.GetProjectUsage(device, pv.ProjectID); err == nil {
+			free := uint64(0)
+			if used < pv.SizeBytes {
+				free = pv.SizeBytes - used
+			}
+			status["filesystem"] = map[string]interface{}{
+				"used-bytes": used,
+				"free-bytes": free,
+				"device":     device,
+			}
+		}
+	}
+	return status
+}
+
+// listPoolVolumes returns the pool volumes indexed on this host
+func (d *VolumeDriver) listPoolVolumes() []*volume.Volume {
+	d.poolMtx.Lock()
+	defer d.poolMtx.Unlock()
+	volumes := make([]*volume.Volume, 0, len(d.poolVolumes))
+	for _, pv := range d.poolVolumes {
+		volumes = append(volumes, &volume.Volume{Name: pv.Name, Mountpoint: getPoolVolumeMountPoint(pv)})
+	}
+	return volumes
+}
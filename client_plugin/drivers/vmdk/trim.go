@@ -0,0 +1,226 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmdk
+
+//
+// Filesystem trim of volumes, set with the "trim" volume option:
+//  off        - never trim (default)
+//  on-unmount - trim before the volume is unmounted
+//  periodic   - trim mounted volumes every trim interval, for volumes
+//               which stay mounted for a long time
+//
+// Trims are serialized with unmounts of the same volume, so a trim never
+// keeps an unmount busy. Volumes are locked one by one, an unmount doesn't
+// wait for the trim of another volume.
+//
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// Trim modes, values of the trim volume option
+const (
+	trimOption    = "trim"
+	trimOff       = "off"
+	trimOnUnmount = "on-unmount"
+	trimPeriodic  = "periodic"
+)
+
+// trimResult - outcome of the last trim of a volume
+type trimResult struct {
+	time      time.Time
+	reclaimed uint64
+	err       error
+}
+
+// volumeLock - serializes trims and unmounts of a volume
+type volumeLock struct {
+	sync.Mutex
+	users int // holders and waiters, the lock is dropped when none are left
+}
+
+// trimmer - trim state of volumes
+type trimmer struct {
+	infoMtx sync.Mutex             // protects locks, modes and results
+	locks   map[string]*volumeLock // volume name -> lock, while used
+	modes   map[string]string      // volume name -> trim mode, for mounted volumes
+	results map[string]trimResult  // volume name -> last trim
+}
+
+func newTrimmer() *trimmer {
+	return &trimmer{
+		locks:   make(map[string]*volumeLock),
+		modes:   make(map[string]string),
+		results: make(map[string]trimResult),
+	}
+}
+
+// lock waits for a trim or unmount of a volume and locks it
+func (t *trimmer) lock(name string) {
+	t.infoMtx.Lock()
+	l, exists := t.locks[name]
+	if !exists {
+		l = &volumeLock{}
+		t.locks[name] = l
+	}
+	l.users++
+	t.infoMtx.Unlock()
+
+	l.Lock()
+}
+
+// unlock unlocks a volume locked with lock
+func (t *trimmer) unlock(name string) {
+	t.infoMtx.Lock()
+	l := t.locks[name]
+	l.users--
+	if l.users == 0 {
+		delete(t.locks, name)
+	}
+	t.infoMtx.Unlock()
+
+	l.Unlock()
+}
+
+// trimModeFromMeta returns the trim mode in volume metadata
+func trimModeFromMeta(meta map[string]interface{}) string {
+	if mode, exists := meta[trimOption].(string); exists && mode != "" {
+		return mode
+	}
+	return trimOff
+}
+
+// setMode remembers the trim mode of a mounted volume
+func (t *trimmer) setMode(name string, mode string) {
+	t.infoMtx.Lock()
+	defer t.infoMtx.Unlock()
+	t.modes[name] = mode
+}
+
+// forget drops the trim mode of a volume which is no longer mounted
+func (t *trimmer) forget(name string) {
+	t.infoMtx.Lock()
+	defer t.infoMtx.Unlock()
+	delete(t.modes, name)
+}
+
+// trim trims the filesystem of a mounted volume and records the result.
+// Caller must hold the lock of the volume.
+func (t *trimmer) trim(logger *log.Entry, name string, mountpoint string) {
+	start := time.Now()
+	reclaimed, err := fs.Trim(logger, mountpoint)
+	if err != nil {
+		logger.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to trim volume ")
+	} else {
+		logger.WithFields(log.Fields{"name": name, "reclaimed": reclaimed,
+			"duration": time.Since(start)}).Info("Volume trimmed ")
+	}
+
+	t.infoMtx.Lock()
+	defer t.infoMtx.Unlock()
+	t.results[name] = trimResult{time: start, reclaimed: reclaimed, err: err}
+}
+
+// status adds the last trim of a volume to its status
+func (t *trimmer) status(name string, status map[string]interface{}) {
+	t.infoMtx.Lock()
+	defer t.infoMtx.Unlock()
+	result, exists := t.results[name]
+	if !exists {
+		return
+	}
+	status["last-trim"] = result.time.Format(time.RFC3339)
+	status["trim-reclaimed-bytes"] = result.reclaimed
+	if result.err != nil {
+		status["trim-error"] = result.err.Error()
+	}
+}
+
+// getTrimMode returns the trim mode of a volume, looking it up in the
+// volume metadata if the volume was mounted before the plugin started
+func (d *VolumeDriver) getTrimMode(name string) string {
+	d.trims.infoMtx.Lock()
+	mode, exists := d.trims.modes[name]
+	d.trims.infoMtx.Unlock()
+	if exists {
+		return mode
+	}
+
+	meta, err := d.ops.Get(name)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to get trim mode, not trimming ")
+		return trimOff
+	}
+	mode = trimModeFromMeta(meta)
+	d.trims.setMode(name, mode)
+	return mode
+}
+
+// trimBeforeUnmount trims a read-write volume with trim=on-unmount.
+// Caller must hold the trim lock of the volume.
+func (d *VolumeDriver) trimBeforeUnmount(name string, mount *plugin_utils.MountInfo) {
+	if isReadOnlyMount(mount) || d.getTrimMode(name) != trimOnUnmount {
+		return
+	}
+	d.trims.trim(d.log, name, getMountPoint(name))
+}
+
+// trimPeriodically trims read-write volumes with trim=periodic every interval
+func (d *VolumeDriver) trimPeriodically(interval time.Duration) {
+	d.log.WithFields(log.Fields{"interval": interval}).Info("Starting periodic volume trim ")
+	for range time.Tick(interval) {
+		mounts, err := plugin_utils.GetMountInfo(mountRoot)
+		if err != nil {
+			continue
+		}
+		for name := range mounts {
+			if d.getTrimMode(name) == trimPeriodic {
+				d.trimMounted(name)
+			}
+		}
+	}
+}
+
+// trimMounted trims a volume if it is still mounted read-write
+func (d *VolumeDriver) trimMounted(name string) {
+	d.trims.lock(name)
+	defer d.trims.unlock(name)
+
+	// the volume may have been unmounted while it was waiting
+	mount, _, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if err != nil || mount == nil || isReadOnlyMount(mount) {
+		return
+	}
+	d.trims.trim(d.log, name, getMountPoint(name))
+}
+
+// isReadOnlyMount checks if a volume is mounted read-only
+func isReadOnlyMount(mount *plugin_utils.MountInfo) bool {
+	if mount == nil {
+		return false
+	}
+	for _, option := range strings.Split(mount.Options, ",") {
+		if option == "ro" {
+			return true
+		}
+	}
+	return false
+}
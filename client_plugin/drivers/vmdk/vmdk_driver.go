@@ -27,55 +27,103 @@ package vmdk
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/drivers/vmdk/vmdkops"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/refcount"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/reqid"
 )
 
 const version = "vSphere Volume Driver v0.5"
 
-// VolumeDriver - VMDK driver struct
+// VolumeDriver - VMDK driver struct. Docker requests are served by copies
+// of the driver made by WithRequestID, which share the driver state.
 type VolumeDriver struct {
+	*driverState
+	ops vmdkops.VmdkOps
+	log *log.Entry // log entry of the request served, see the reqid package
+}
+
+// driverState - state of the driver, shared by the request copies
+type driverState struct {
 	useMockEsx    bool
-	ops           vmdkops.VmdkOps
 	refCounts     *refcount.RefCountsMap
-	mountIDtoName map[string]string // map of mountID -> full volume name
+	mountIDtoName map[string]string      // map of mountID -> full volume name
+	unmountPolicy fs.UnmountPolicy       // escalation for busy filesystems
+	trims         *trimmer               // filesystem trim of volumes
+	mountedMtx    sync.Mutex             // protects mountedSince
+	mountedSince  map[string]time.Time   // volume name -> time of mount
+	poolMtx       sync.Mutex             // protects poolVolumes and pools
+	poolVolumes   map[string]*poolVolume // volumes kept in pools, see pool.go
+	pools         map[string]bool        // volumes holding pool volumes
+	encryption    *encryptor             // encryption of volumes, see encrypt.go
+	freezes       *freezer               // frozen volumes, see freeze.go
+	createClasses *createClasses         // default create options and classes, see classes.go
+	createMtx     sync.Mutex             // protects creating
+	creating      map[string]bool        // volumes attached by Create to format them
 }
 
 var mountRoot string
 
-// NewVolumeDriver creates Driver which to real ESX (useMockEsx=False) or a mock
-func NewVolumeDriver(port int, useMockEsx bool, mountDir string, driverName string) *VolumeDriver {
+// NewVolumeDriver creates Driver which to real ESX (cfg.UseMockEsx=False) or a mock
+func NewVolumeDriver(cfg config.Config, mountDir string) *VolumeDriver {
 	var d *VolumeDriver
 
-	vmdkops.EsxPort = port
+	vmdkops.EsxPort = cfg.Port
+	vmdkops.SetRetryPolicy(cfg.EsxRetryCount, time.Duration(cfg.EsxRetryIntervalMs)*time.Millisecond)
 	mountRoot = mountDir
+	useMockEsx := cfg.UseMockEsx
 
 	if useMockEsx {
 		d = &VolumeDriver{
-			useMockEsx: true,
-			ops:        vmdkops.VmdkOps{Cmd: vmdkops.NewMockCmd()},
-			refCounts:  refcount.NewRefCountsMap(),
+			driverState: &driverState{
+				useMockEsx: true,
+				refCounts:  refcount.NewRefCountsMap(),
+			},
+			ops: vmdkops.VmdkOps{Cmd: vmdkops.NewMockCmd()},
+			log: reqid.Log(""),
 		}
 	} else {
 		d = &VolumeDriver{
-			useMockEsx: false,
+			driverState: &driverState{
+				useMockEsx: false,
+				refCounts:  refcount.NewRefCountsMap(),
+			},
 			ops: vmdkops.VmdkOps{
 				Cmd: vmdkops.EsxVmdkCmd{
 					Mtx: &sync.Mutex{},
 				},
 			},
-			refCounts: refcount.NewRefCountsMap(),
+			log: reqid.Log(""),
 		}
 	}
 
 	d.mountIDtoName = make(map[string]string)
-	d.refCounts.Init(d, mountDir, driverName)
+	d.mountedSince = make(map[string]time.Time)
+	d.creating = make(map[string]bool)
+	d.pools = make(map[string]bool)
+	d.loadPoolIndex()
+	d.unmountPolicy = fs.DefaultUnmountPolicy()
+	d.unmountPolicy.Force = cfg.UnmountForce
+	d.unmountPolicy.Lazy = cfg.UnmountLazy
+	d.trims = newTrimmer()
+	d.encryption = newEncryptor(cfg)
+	d.freezes = newFreezer()
+	d.createClasses = newCreateClasses(cfg)
+	d.refCounts.SetOrphanDryRun(cfg.OrphanDetachDryRun)
+	d.refCounts.Init(d, mountDir, cfg.Driver)
+	metrics.RegisterCollector(func() { d.collectMetrics(cfg.Driver) })
+	if cfg.TrimIntervalHours > 0 {
+		go d.trimPeriodically(time.Duration(cfg.TrimIntervalHours) * time.Hour)
+	}
 
 	log.WithFields(log.Fields{
 		"version":  version,
@@ -86,6 +134,21 @@ func NewVolumeDriver(port int, useMockEsx bool, mountDir string, driverName stri
 	return d
 }
 
+// WithRequestID returns a copy of the driver serving the Docker request
+// with ID id, which logs the ID and sends it to ESX along with its requests
+func (d *VolumeDriver) WithRequestID(id string) volume.Driver {
+	served := *d
+	served.ops = d.ops.WithRequestID(id)
+	served.log = reqid.Log(id)
+	return &served
+}
+
+// Reload applies the runtime fields of a reloaded configuration
+func (d *VolumeDriver) Reload(cfg config.Config) {
+	vmdkops.SetRetryPolicy(cfg.EsxRetryCount, time.Duration(cfg.EsxRetryIntervalMs)*time.Millisecond)
+	d.createClasses.set(cfg)
+}
+
 // In following three operations on refcount, if refcount
 // map hasn't been initialized, return 1 to prevent detach and remove.
 
@@ -110,7 +173,7 @@ func (d *VolumeDriver) decrRefCount(vol string) (uint, error) {
 	if d.refCounts.IsInitialized() != true {
 		return 1, nil
 	}
-	return d.refCounts.Decr(vol)
+	return d.refCounts.Decr(d.log, vol)
 }
 
 // Returns the given volume mountpoint
@@ -124,12 +187,67 @@ func (d *VolumeDriver) Get(r volume.Request) volume.Response {
 	if err != nil {
 		return volume.Response{Err: err.Error()}
 	}
+	// trims, freezes and mounts are tracked by the full name, Docker
+	// may ask with the short one
+	fullName := r.Name
+	if datastore, exists := status["datastore"].(string); exists {
+		if volumeInfo, err := plugin_utils.GetVolumeInfo(r.Name, datastore, d); err == nil {
+			fullName = volumeInfo.VolumeName
+		}
+	}
+	d.trims.status(fullName, status)
+	d.freezes.status(fullName, status)
+	d.addUsage(fullName, status)
 	mountpoint := getMountPoint(r.Name)
+	if pv := d.getPoolVolume(r.Name); pv != nil {
+		mountpoint = getPoolVolumeMountPoint(pv)
+	}
 	return volume.Response{Volume: &volume.Volume{Name: r.Name,
 		Mountpoint: mountpoint,
 		Status:     status}}
 }
 
+// addUsage adds guest side stats of a volume mounted here to its status
+func (d *VolumeDriver) addUsage(name string, status map[string]interface{}) {
+	mount, _, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if err != nil || mount == nil {
+		return
+	}
+	usage, err := fs.GetUsage(getMountPoint(name))
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to get filesystem usage ")
+		return
+	}
+
+	fsStatus := map[string]interface{}{
+		"used-bytes":    usage.UsedBytes,
+		"free-bytes":    usage.FreeBytes,
+		"inodes-used":   usage.InodesUsed,
+		"inodes-free":   usage.InodesFree,
+		"mount-options": mount.Options,
+		"device":        mount.Source,
+	}
+	// unknown for volumes mounted before the plugin started
+	d.mountedMtx.Lock()
+	since, exists := d.mountedSince[name]
+	d.mountedMtx.Unlock()
+	if exists {
+		fsStatus["mounted-since"] = since.Format(time.RFC3339)
+	}
+	status["filesystem"] = fsStatus
+}
+
+// setMounted records the mount time of a volume, or forgets it on unmount
+func (d *VolumeDriver) setMounted(name string, mounted bool) {
+	d.mountedMtx.Lock()
+	defer d.mountedMtx.Unlock()
+	if mounted {
+		d.mountedSince[name] = time.Now()
+	} else {
+		delete(d.mountedSince, name)
+	}
+}
+
 // List volumes known to the driver
 func (d *VolumeDriver) List(r volume.Request) volume.Response {
 	volumes, err := d.ops.List()
@@ -142,14 +260,28 @@ func (d *VolumeDriver) List(r volume.Request) volume.Response {
 		responseVol := volume.Volume{Name: vol.Name, Mountpoint: mountpoint}
 		responseVolumes = append(responseVolumes, &responseVol)
 	}
+	responseVolumes = append(responseVolumes, d.listPoolVolumes()...)
 	return volume.Response{Volumes: responseVolumes}
 }
 
 // GetVolume - return volume meta-data.
 func (d *VolumeDriver) GetVolume(name string) (map[string]interface{}, error) {
+	if pv := d.getPoolVolume(name); pv != nil {
+		return d.getPoolVolumeStatus(pv), nil
+	}
 	return d.ops.Get(name)
 }
 
+// FullName returns the volume name qualified with its datastore, or name
+// if the volume can't be found
+func (d *VolumeDriver) FullName(name string) string {
+	volumeInfo, err := plugin_utils.GetVolumeInfo(name, "", d)
+	if err != nil {
+		return name
+	}
+	return volumeInfo.VolumeName
+}
+
 // MountVolume - Request attach and them mounts the volume.
 // Actual mount - send attach to ESX and do the in-guest magic
 // Returns mount point and  error (or nil)
@@ -159,55 +291,212 @@ func (d *VolumeDriver) MountVolume(name string, fstype string, id string, isRead
 	// First, make sure  that mountpoint exists.
 	err := fs.Mkdir(mountpoint)
 	if err != nil {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": name, "dir": mountpoint},
 		).Error("Failed to make directory for volume mount ")
 		return mountpoint, err
 	}
 
-	waitCtx, errWait := fs.DevAttachWaitPrep()
-	if errWait != nil {
-		log.WithFields(log.Fields{"name": name,
-			"error": errWait}).Warning("Failed to initialize wait context, continuing however.. ")
-	}
-
 	if d.useMockEsx {
 		dev, err := d.ops.RawAttach(name, nil)
 		if err != nil {
-			log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to attach volume ")
+			d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to attach volume ")
 			return mountpoint, err
 		}
-		return mountpoint, fs.MountByDevicePath(mountpoint, fstype, string(dev[:]), false)
+		return mountpoint, fs.MountByDevicePath(d.log, mountpoint, fstype, string(dev[:]), false)
+	}
+
+	watcher, err := fs.DevAttachWaitPrep(d.log)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name,
+			"error": err}).Error("Failed to initialize wait context ")
+		return mountpoint, err
 	}
+	defer watcher.Close()
 
 	volDev, err := d.ops.Attach(name, nil)
 	if err != nil {
-		log.WithFields(log.Fields{"name": name, "error": err}).Error("Attach volume failed ")
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Attach volume failed ")
 		return mountpoint, err
 	}
 
-	if errWait != nil {
-		fs.DevAttachWaitFallback()
-		return mountpoint, fs.Mount(mountpoint, fstype, volDev, false)
+	// Don't mount unless the attached device showed up
+	device, err := fs.DevAttachWait(d.log, watcher, volDev)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Could not find attached device ")
+		return mountpoint, err
+	}
+	if err = fs.VerifyDevice(device, volDev); err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Attached device doesn't match the volume ")
+		return mountpoint, err
+	}
+
+	// the filesystem of an encrypted volume is on the LUKS mapping
+	device, err = d.openLuks(name, device)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to open encrypted volume ")
+		return mountpoint, err
+	}
+
+	// pools keep volumes limited by project quotas
+	options := ""
+	if d.isPool(name) {
+		if err = fs.EnableProjectQuota(fstype, device); err != nil {
+			d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Failed to enable project quota for pool ")
+		}
+		options = fs.ProjectQuotaOption
+	}
+	if err == nil {
+		err = fs.MountByDevicePathWithOptions(d.log, mountpoint, fstype, device, isReadOnly, options)
+	}
+	if err != nil {
+		d.closeLuks(name)
+		return mountpoint, err
+	}
+
+	// the disk may have been resized while the volume was detached
+	if !isReadOnly {
+		if err = fs.GrowFilesystem(d.log, fstype, device, mountpoint); err != nil {
+			d.log.WithFields(log.Fields{"name": name, "error": err}).Warning("Failed to grow filesystem ")
+		}
+	}
+	return mountpoint, nil
+}
+
+// ListAttachedVolumes - return full names of volumes attached to this VM
+func (d *VolumeDriver) ListAttachedVolumes() ([]string, error) {
+	volumes, err := d.ops.ListVMAttached()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(volumes))
+	for _, vol := range volumes {
+		// attached to be formatted, not an orphan
+		if d.isCreating(vol.Name) {
+			continue
+		}
+		names = append(names, vol.Name)
+	}
+	return names, nil
+}
+
+// setCreating marks a volume as attached by Create, or clears the mark
+func (d *VolumeDriver) setCreating(name string, creating bool) {
+	d.createMtx.Lock()
+	defer d.createMtx.Unlock()
+	if creating {
+		d.creating[name] = true
+	} else {
+		delete(d.creating, name)
 	}
+}
+
+// isCreating returns if a volume is attached by Create
+func (d *VolumeDriver) isCreating(name string) bool {
+	d.createMtx.Lock()
+	defer d.createMtx.Unlock()
+	return d.creating[name]
+}
 
-	fs.DevAttachWait(waitCtx, volDev)
+// DetachVolume - detach a volume which is not mounted, removing its disk
+// from the guest first
+func (d *VolumeDriver) DetachVolume(name string) error {
+	if d.isCreating(name) {
+		return fmt.Errorf("Volume %s is being created", name)
+	}
+	if _, err := d.closeLuks(name); err != nil {
+		return err
+	}
+	if volDev := d.getAttachedDevSpec(name); volDev != nil {
+		if device, err := fs.GetDevicePath(volDev); err == nil {
+			d.deleteDevice(name, device)
+		}
+	}
+	return d.detach(name)
+}
 
-	// May have timed out waiting for the attach to complete,
-	// attempt the mount anyway.
-	return mountpoint, fs.Mount(mountpoint, fstype, volDev, isReadOnly)
+// getAttachedDevSpec - return where the volume is attached to this VM, or nil
+func (d *VolumeDriver) getAttachedDevSpec(name string) *fs.VolumeDevSpec {
+	volumes, err := d.ops.ListVMAttached()
+	if err != nil {
+		return nil
+	}
+	for _, vol := range volumes {
+		if vol.Name != name || vol.Attributes["Unit"] == "" {
+			continue
+		}
+		return &fs.VolumeDevSpec{
+			Unit:                    vol.Attributes["Unit"],
+			ControllerPciSlotNumber: vol.Attributes["ControllerPciSlotNumber"],
+			ControllerType:          vol.Attributes["ControllerType"],
+			Bus:                     vol.Attributes["Bus"],
+			DiskUUID:                vol.Attributes["DiskUUID"],
+		}
+	}
+	return nil
+}
+
+// deleteDevice - flush and remove the disk device of a volume from the
+// guest before detach, so no stale device is left behind
+func (d *VolumeDriver) deleteDevice(name string, device string) {
+	err := fs.DeleteDevice(d.log, device)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "device": device,
+			"error": err}).Warning("Failed to delete device, continuing with detach ")
+	}
 }
 
 // UnmountVolume - Unmounts the volume and then requests detach
 func (d *VolumeDriver) UnmountVolume(name string) error {
+	return d.unmountVolume(name, d.unmountPolicy)
+}
+
+// unmountVolume - Unmounts the volume per policy and then requests detach
+func (d *VolumeDriver) unmountVolume(name string, policy fs.UnmountPolicy) error {
+	// wait for a running trim of the volume, and don't start one until unmounted
+	d.trims.lock(name)
+	defer d.trims.unlock(name)
+
 	mountpoint := getMountPoint(name)
-	err := fs.Unmount(mountpoint)
-	if err != nil {
-		log.WithFields(
+	mount, others, err := plugin_utils.GetVolumeMounts(name, mountRoot)
+	if len(others) > 0 {
+		// the device can't be detached while the filesystem is mounted elsewhere
+		d.log.WithFields(
+			log.Fields{"mountpoint": mountpoint, "others": others},
+		).Error("Volume filesystem is also mounted elsewhere, skipping unmount and detach ")
+		return fmt.Errorf("Volume %s is also mounted at %v", name, others)
+	}
+	if mount != nil {
+		d.thawBeforeUnmount(name)
+		d.trimBeforeUnmount(name, mount)
+	}
+	if err == nil && mount == nil {
+		d.log.WithFields(
+			log.Fields{"mountpoint": mountpoint},
+		).Warning("Volume is not mounted, skipping unmount. Now trying to detach... ")
+	} else if err = fs.SafeUnmount(d.log, mountpoint, policy); err != nil {
+		// detaching would pull the disk from under open files
+		d.log.WithFields(
 			log.Fields{"mountpoint": mountpoint, "error": err},
-		).Error("Failed to unmount volume. Now trying to detach... ")
-		// Do not return error. Continue with detach.
+		).Error("Failed to unmount volume, skipping detach ")
+		return err
 	}
+
+	// the LUKS mapping of an encrypted volume keeps the disk open
+	device, err := d.closeLuks(name)
+	if err != nil {
+		d.log.WithFields(log.Fields{"name": name, "error": err}).Error("Skipping detach ")
+		return err
+	}
+	if device == "" && mount != nil {
+		device, _ = fs.GetDevicePathByNumber(mount.Major, mount.Minor)
+	}
+	if device != "" {
+		// the device is unused now, remove it from the guest
+		d.deleteDevice(name, device)
+	}
+	d.trims.forget(name)
+	d.setMounted(name, false)
 	return d.ops.Detach(name, nil)
 }
 
@@ -215,25 +504,29 @@ func (d *VolumeDriver) UnmountVolume(name string) error {
 func (d *VolumeDriver) processMount(r volume.MountRequest) volume.Response {
 	volumeInfo, err := plugin_utils.GetVolumeInfo(r.Name, "", d)
 	if err != nil {
-		log.Errorf("Unable to get volume info for volume %s. err:%v", r.Name, err)
+		d.log.Errorf("Unable to get volume info for volume %s. err:%v", r.Name, err)
 		return volume.Response{Err: err.Error()}
 	}
 	r.Name = volumeInfo.VolumeName
 	d.mountIDtoName[r.ID] = r.Name
 
+	if pv := d.getPoolVolume(r.Name); pv != nil {
+		return d.mountPoolVolume(pv)
+	}
+
 	// If the volume is already mounted , just increase the refcount.
 	// Note: for new keys, GO maps return zero value, so no need for if_exists.
 	refcnt := d.incrRefCount(r.Name) // save map traversal
-	log.Debugf("volume name=%s refcnt=%d", r.Name, refcnt)
+	d.log.Debugf("volume name=%s refcnt=%d", r.Name, refcnt)
 	if refcnt > 1 {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "refcount": refcnt},
 		).Info("Already mounted, skipping mount. ")
 		return volume.Response{Mountpoint: getMountPoint(r.Name)}
 	}
 
 	if plugin_utils.AlreadyMounted(r.Name, mountRoot) {
-		log.WithFields(log.Fields{"name": r.Name}).Info("Already mounted, skipping mount. ")
+		d.log.WithFields(log.Fields{"name": r.Name}).Info("Already mounted, skipping mount. ")
 		return volume.Response{Mountpoint: getMountPoint(r.Name)}
 	}
 
@@ -256,7 +549,7 @@ func (d *VolumeDriver) processMount(r volume.MountRequest) volume.Response {
 	value, exists := volumeMeta["access"].(string)
 	if !exists {
 		msg := fmt.Sprintf("Invalid access type for %s, assuming read-write access.", r.Name)
-		log.WithFields(log.Fields{"name": r.Name, "error": msg}).Error("")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": msg}).Error("")
 		isReadOnly = false
 	} else if value == "read-only" {
 		isReadOnly = true
@@ -267,48 +560,57 @@ func (d *VolumeDriver) processMount(r volume.MountRequest) volume.Response {
 	if !exists {
 		msg := fmt.Sprintf("Invalid filesystem type for %s, assuming type as %s.",
 			r.Name, fstype)
-		log.WithFields(log.Fields{"name": r.Name, "error": msg}).Error("")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": msg}).Error("")
 		// Fail back to a default version that we can try with.
 		value = fs.FstypeDefault
 	}
 	fstype = value
+	d.trims.setMode(r.Name, trimModeFromMeta(volumeMeta))
+	d.setEncryption(r.Name, volumeMeta)
 
 	mountpoint, err := d.MountVolume(r.Name, fstype, "", isReadOnly, false)
 	if err != nil {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "error": err.Error()},
 		).Error("Failed to mount ")
 
 		refcnt, _ := d.decrRefCount(r.Name)
 		if refcnt == 0 {
-			log.Infof("Detaching %s - it is not used anymore", r.Name)
+			d.log.Infof("Detaching %s - it is not used anymore", r.Name)
 			d.ops.Detach(r.Name, nil) // try to detach before failing the request for volume
 		}
 		return volume.Response{Err: err.Error()}
 	}
 
+	d.setMounted(r.Name, true)
 	return volume.Response{Mountpoint: mountpoint}
 }
 
 // prepareCreateOptions sets default options for create request r.
 func (d *VolumeDriver) prepareCreateOptions(r volume.Request) error {
-	if r.Options == nil {
-		r.Options = make(map[string]string)
+	if err := d.prepareClassOptions(r); err != nil {
+		d.log.WithFields(log.Fields{"name": r.Name, "error": err}).Error("Invalid create options ")
+		return err
 	}
 
 	// Use default fstype if both fstype and clone-from are not specified.
 	_, fstypeRes := r.Options["fstype"]
 	_, cloneFromRes := r.Options["clone-from"]
 	if !fstypeRes && !cloneFromRes {
-		log.WithFields(log.Fields{"req": r}).Debugf("Setting fstype to %s ", fs.FstypeDefault)
+		d.log.WithFields(log.Fields{"req": r}).Debugf("Setting fstype to %s ", fs.FstypeDefault)
 		r.Options["fstype"] = fs.FstypeDefault
 	}
 
+	if err := d.prepareEncryptOptions(r); err != nil {
+		d.log.WithFields(log.Fields{"name": r.Name, "error": err}).Error("Invalid encryption ")
+		return err
+	}
+
 	// Check whether the fstype filesystem is supported.
 	if _, fstypeRes = r.Options["fstype"]; fstypeRes {
 		err := fs.VerifyFSSupport(r.Options["fstype"])
 		if err != nil {
-			log.WithFields(log.Fields{"name": r.Name, "fstype": r.Options["fstype"],
+			d.log.WithFields(log.Fields{"name": r.Name, "fstype": r.Options["fstype"],
 				"error": err}).Error("Not supported ")
 			return err
 		}
@@ -320,7 +622,7 @@ func (d *VolumeDriver) prepareCreateOptions(r volume.Request) error {
 func (d *VolumeDriver) cloneFrom(r volume.Request) volume.Response {
 	errClone := d.ops.Create(r.Name, r.Options)
 	if errClone != nil {
-		log.WithFields(log.Fields{"name": r.Name, "error": errClone}).Error("Clone volume failed ")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": errClone}).Error("Clone volume failed ")
 		return volume.Response{Err: errClone.Error()}
 	}
 	return volume.Response{Err: ""}
@@ -330,7 +632,7 @@ func (d *VolumeDriver) cloneFrom(r volume.Request) volume.Response {
 func (d *VolumeDriver) detach(name string) error {
 	errDetach := d.ops.Detach(name, nil)
 	if errDetach != nil {
-		log.WithFields(log.Fields{"name": name, "error": errDetach}).Warning("Detach volume failed ")
+		d.log.WithFields(log.Fields{"name": name, "error": errDetach}).Warning("Detach volume failed ")
 	}
 	return errDetach
 }
@@ -339,7 +641,7 @@ func (d *VolumeDriver) detach(name string) error {
 func (d *VolumeDriver) remove(name string) error {
 	errRemove := d.ops.Remove(name, nil)
 	if errRemove != nil {
-		log.WithFields(log.Fields{"name": name, "error": errRemove}).Warning("Remove volume failed ")
+		d.log.WithFields(log.Fields{"name": name, "error": errRemove}).Warning("Remove volume failed ")
 	}
 	return errRemove
 }
@@ -356,9 +658,16 @@ func (d *VolumeDriver) detachAndRemove(name string) {
 
 // Create creates a volume.
 func (d *VolumeDriver) Create(r volume.Request) volume.Response {
+	if _, result := r.Options[poolOption]; result {
+		return d.createPoolVolume(r)
+	}
+	if r.Options == nil {
+		r.Options = make(map[string]string)
+	}
+
 	err := d.prepareCreateOptions(r)
 	if err != nil {
-		log.WithFields(log.Fields{"name": r.Name, "error": err}).Error("Failed to prepare options ")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": err}).Error("Failed to prepare options ")
 		return volume.Response{Err: err.Error()}
 	}
 
@@ -369,70 +678,102 @@ func (d *VolumeDriver) Create(r volume.Request) volume.Response {
 
 	errCreate := d.ops.Create(r.Name, r.Options)
 	if errCreate != nil {
-		log.WithFields(log.Fields{"name": r.Name, "error": errCreate}).Error("Create volume failed ")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": errCreate}).Error("Create volume failed ")
 		return volume.Response{Err: errCreate.Error()}
 	}
 
 	// Handle filesystem creation
-	log.WithFields(log.Fields{"name": r.Name,
+	d.log.WithFields(log.Fields{"name": r.Name,
 		"fstype": r.Options["fstype"]}).Info("Attaching volume and creating filesystem ")
 
-	waitCtx, errWait := fs.DevAttachWaitPrep()
+	// refcount discovery must not detach the disk as an orphan while it
+	// is formatted
+	fullName := d.FullName(r.Name)
+	d.setCreating(fullName, true)
+	defer d.setCreating(fullName, false)
+
+	watcher, errWait := fs.DevAttachWaitPrep(d.log)
 	if errWait != nil {
-		log.WithFields(log.Fields{"name": r.Name,
-			"error": errWait}).Warning("Failed to initialize wait context, continuing however.. ")
+		d.log.WithFields(log.Fields{"name": r.Name,
+			"error": errWait}).Error("Failed to initialize wait context, removing the volume ")
+		d.remove(r.Name)
+		return volume.Response{Err: errWait.Error()}
 	}
+	defer watcher.Close()
 
 	volDev, errAttach := d.ops.Attach(r.Name, nil)
 	if errAttach != nil {
-		log.WithFields(log.Fields{"name": r.Name,
+		d.log.WithFields(log.Fields{"name": r.Name,
 			"error": errAttach}).Error("Attach volume failed, removing the volume ")
 		d.remove(r.Name)
 		return volume.Response{Err: errAttach.Error()}
 	}
 
-	if errWait != nil {
-		fs.DevAttachWaitFallback()
-	} else {
-		// Wait for the attach to complete, may timeout
-		// in which case we continue creating the file system.
-		errAttachWait := fs.DevAttachWait(waitCtx, volDev)
-		if errAttachWait != nil {
-			log.WithFields(log.Fields{"name": r.Name,
-				"error": errAttachWait}).Error("Could not find attached device, removing the volume ")
+	// Wait for the attach to complete, don't create the file system
+	// on a device that didn't show up
+	device, errAttachWait := fs.DevAttachWait(d.log, watcher, volDev)
+	if errAttachWait != nil {
+		d.log.WithFields(log.Fields{"name": r.Name,
+			"error": errAttachWait}).Error("Could not find attached device, removing the volume ")
+		d.detachAndRemove(r.Name)
+		return volume.Response{Err: errAttachWait.Error()}
+	}
+
+	// Never create a filesystem on a disk other than the new volume
+	errVerify := fs.VerifyDevice(device, volDev)
+	if errVerify != nil {
+		d.log.WithFields(log.Fields{"name": r.Name, "device": device,
+			"error": errVerify}).Error("Attached device doesn't match the volume, removing the volume ")
+		d.detachAndRemove(r.Name)
+		return volume.Response{Err: errVerify.Error()}
+	}
+
+	// encrypted volumes get the filesystem in a LUKS container
+	mkfsDevice := device
+	if r.Options[encryptOption] == encryptLuks {
+		var errLuks error
+		if mkfsDevice, errLuks = d.formatLuks(r, device); errLuks != nil {
+			d.log.WithFields(log.Fields{"name": r.Name,
+				"error": errLuks}).Error("Create LUKS container failed, removing the volume ")
+			d.closeLuks(r.Name)
 			d.detachAndRemove(r.Name)
-			return volume.Response{Err: errAttachWait.Error()}
+			return volume.Response{Err: errLuks.Error()}
 		}
 	}
 
-	errMkfs := fs.Mkfs(r.Options["fstype"], r.Name, volDev)
+	errMkfs := fs.MkfsWithOptions(r.Options["fstype"], r.Name, mkfsDevice,
+		strings.Fields(r.Options[mkfsOptionsOption]))
+	if mkfsDevice != device {
+		d.closeLuks(r.Name)
+	}
 	if errMkfs != nil {
-		log.WithFields(log.Fields{"name": r.Name,
+		d.log.WithFields(log.Fields{"name": r.Name,
 			"error": errMkfs}).Error("Create filesystem failed, removing the volume ")
 		d.detachAndRemove(r.Name)
 		return volume.Response{Err: errMkfs.Error()}
 	}
 
+	d.deleteDevice(r.Name, device)
 	errDetach := d.ops.Detach(r.Name, nil)
 	if errDetach != nil {
-		log.WithFields(log.Fields{"name": r.Name, "error": errDetach}).Error("Detach volume failed ")
+		d.log.WithFields(log.Fields{"name": r.Name, "error": errDetach}).Error("Detach volume failed ")
 		return volume.Response{Err: errDetach.Error()}
 	}
 
-	log.WithFields(log.Fields{"name": r.Name,
+	d.log.WithFields(log.Fields{"name": r.Name,
 		"fstype": r.Options["fstype"]}).Info("Volume and filesystem created ")
 	return volume.Response{Err: ""}
 }
 
 // Remove - removes individual volume. Docker would call it only if is not using it anymore
 func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
-	log.WithFields(log.Fields{"name": r.Name}).Info("Removing volume ")
+	d.log.WithFields(log.Fields{"name": r.Name}).Info("Removing volume ")
 
 	// Cannot remove volumes till plugin completely initializes (refcounting is complete)
 	// because we don't know if it is being used or not
 	if d.refCounts.IsInitialized() != true {
-		msg := fmt.Sprintf(plugin_utils.PluginInitError+" Cannot remove volume=%s", r.Name)
-		log.Error(msg)
+		msg := fmt.Sprintf(d.refCounts.NotReadyMsg()+" Cannot remove volume=%s", r.Name)
+		d.log.Error(msg)
 		return volume.Response{Err: msg}
 	}
 
@@ -440,13 +781,22 @@ func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
 	if d.getRefCount(r.Name) != 0 {
 		msg := fmt.Sprintf("Remove failure - volume is still mounted. "+
 			" volume=%s, refcount=%d", r.Name, d.getRefCount(r.Name))
-		log.Error(msg)
+		d.log.Error(msg)
+		return volume.Response{Err: msg}
+	}
+
+	if pv := d.getPoolVolume(r.Name); pv != nil {
+		return d.removePoolVolume(pv)
+	}
+	if d.isPool(r.Name) {
+		msg := fmt.Sprintf("Remove failure - volume %s holds pool volumes", r.Name)
+		d.log.Error(msg)
 		return volume.Response{Err: msg}
 	}
 
 	err := d.ops.Remove(r.Name, r.Options)
 	if err != nil {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "error": err},
 		).Error("Failed to remove volume ")
 		return volume.Response{Err: err.Error()}
@@ -457,6 +807,9 @@ func (d *VolumeDriver) Remove(r volume.Request) volume.Response {
 
 // Path - give docker a reminder of the volume mount path
 func (d *VolumeDriver) Path(r volume.Request) volume.Response {
+	if pv := d.getPoolVolume(r.Name); pv != nil {
+		return volume.Response{Mountpoint: getPoolVolumeMountPoint(pv)}
+	}
 	return volume.Response{Mountpoint: getMountPoint(r.Name)}
 }
 
@@ -469,14 +822,13 @@ func (d *VolumeDriver) Path(r volume.Request) volume.Response {
 // at this level during create/mount/umount/remove.
 //
 func (d *VolumeDriver) Mount(r volume.MountRequest) volume.Response {
-	log.WithFields(log.Fields{"name": r.Name}).Info("Mounting volume ")
+	d.log.WithFields(log.Fields{"name": r.Name}).Info("Mounting volume ")
 
 	// lock the state
 	d.refCounts.StateMtx.Lock()
 	defer d.refCounts.StateMtx.Unlock()
 
-	// checked by refcounting thread until refmap initialized
-	// useless after that
+	// checked by refcounting thread on discovery
 	d.refCounts.MarkDirty()
 
 	return d.processMount(r)
@@ -485,17 +837,22 @@ func (d *VolumeDriver) Mount(r volume.MountRequest) volume.Response {
 // Unmount request from Docker. If mount refcount is drop to 0.
 // Unmount and detach from VM
 func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
-	log.WithFields(log.Fields{"name": r.Name}).Info("Unmounting Volume ")
+	d.log.WithFields(log.Fields{"name": r.Name}).Info("Unmounting Volume ")
 
 	// lock the state
 	d.refCounts.StateMtx.Lock()
 	defer d.refCounts.StateMtx.Unlock()
 
+	// discovery running meanwhile, e.g. a reconcile, must not use counts
+	// from before this unmount
+	d.refCounts.MarkDirty()
+
 	if d.refCounts.IsInitialized() != true {
 		// if refcounting hasn't been succesful,
 		// no refcounting, no unmount. All unmounts are delayed
 		// until we succesfully populate the refcount map
-		d.refCounts.MarkDirty()
+		d.log.WithFields(log.Fields{"name": r.Name, "state": d.refCounts.GetState()}).Warning(
+			"Refcounts not available, deferring unmount to refcount recovery ")
 		return volume.Response{Err: ""}
 	}
 
@@ -505,7 +862,7 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 	} else {
 		volumeInfo, err := plugin_utils.GetVolumeInfo(r.Name, "", d)
 		if err != nil {
-			log.Errorf("Unable to get volume info for volume %s. err:%v", r.Name, err)
+			d.log.Errorf("Unable to get volume info for volume %s. err:%v", r.Name, err)
 			return volume.Response{Err: err.Error()}
 		}
 		r.Name = volumeInfo.VolumeName
@@ -516,22 +873,28 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 	refcnt, err := d.decrRefCount(r.Name)
 	if err != nil {
 		// something went wrong - yell, but still try to unmount
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "refcount": refcnt},
 		).Error("Refcount error - still trying to unmount...")
 	}
-	log.Debugf("volume name=%s refcnt=%d", r.Name, refcnt)
+	d.log.Debugf("volume name=%s refcnt=%d", r.Name, refcnt)
 	if refcnt >= 1 {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "refcount": refcnt},
 		).Info("Still in use, skipping unmount request. ")
 		return volume.Response{Err: ""}
 	}
 
+	// the pool is unmounted when none of its volumes is used
+	if pv := d.getPoolVolume(r.Name); pv != nil {
+		d.releasePool(pv.Pool)
+		return volume.Response{Err: ""}
+	}
+
 	// and if nobody needs it, unmount and detach
 	err = d.UnmountVolume(r.Name)
 	if err != nil {
-		log.WithFields(
+		d.log.WithFields(
 			log.Fields{"name": r.Name, "error": err.Error()},
 		).Error("Failed to unmount ")
 		return volume.Response{Err: err.Error()}
@@ -539,6 +902,11 @@ func (d *VolumeDriver) Unmount(r volume.UnmountRequest) volume.Response {
 	return volume.Response{Err: ""}
 }
 
+// Status - report plugin health, see refcount.PluginState
+func (d *VolumeDriver) Status() map[string]interface{} {
+	return d.refCounts.GetStatus()
+}
+
 // Capabilities - Report plugin scope to Docker
 func (d *VolumeDriver) Capabilities(r volume.Request) volume.Response {
 	return volume.Response{Capabilities: volume.Capability{Scope: "global"}}
@@ -30,6 +30,7 @@ import (
 	"unsafe"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
 )
 
 /*
@@ -42,12 +43,12 @@ import "C"
 
 // EsxVmdkCmd struct - empty , we use it only to implement VmdkCmdRunner interface
 type EsxVmdkCmd struct {
-	Mtx *sync.Mutex // For serialization of Run comand/response
+	Mtx       *sync.Mutex // For serialization of Run comand/response
+	RequestID string      // ID of the Docker request served, logged by the ESX service
 }
 
 const (
 	commBackendName string = "vsocket"
-	maxRetryCount          = 5
 	// Server side understand protocol version. If you are changing client/server protocol we use
 	// over VMCI, PLEASE DO NOT FORGET TO CHANGE IT FOR SERVER in file <vmdk_ops.py> !
 	clientProtocolVersion = "2"
@@ -55,9 +56,10 @@ const (
 
 // A request to be passed to ESX service
 type requestToVmci struct {
-	Ops     string     `json:"cmd"`
-	Details VolumeInfo `json:"details"`
-	Version string     `json:"version,omitempty"`
+	Ops       string     `json:"cmd"`
+	Details   VolumeInfo `json:"details"`
+	Version   string     `json:"version,omitempty"`
+	RequestID string     `json:"reqid,omitempty"` // logged by the ESX service
 }
 
 // VolumeInfo we get about the volume from upstairs
@@ -73,6 +75,54 @@ type vmciError struct {
 // EsxPort used to connect to ESX, passed in as command line param
 var EsxPort int
 
+// Retry policy of requests which fail to reach ESX, see SetRetryPolicy
+var (
+	retryMtx      sync.Mutex
+	retryCount    = 5
+	retryInterval = time.Second
+)
+
+// SetRetryPolicy sets the number of retries of requests which fail to
+// reach ESX and the interval between them
+func SetRetryPolicy(count int, interval time.Duration) {
+	retryMtx.Lock()
+	defer retryMtx.Unlock()
+	retryCount = count
+	retryInterval = interval
+}
+
+// getRetryPolicy returns the number of retries and the retry interval
+func getRetryPolicy() (int, time.Duration) {
+	retryMtx.Lock()
+	defer retryMtx.Unlock()
+	return retryCount, retryInterval
+}
+
+// Outcome of the last request sent to ESX, see LastRoundTrip
+var (
+	roundTripMtx  sync.Mutex
+	roundTripTime time.Time
+	roundTripErr  error
+)
+
+// setRoundTrip records the outcome of a request sent to ESX, err is nil
+// if ESX answered
+func setRoundTrip(err error) {
+	roundTripMtx.Lock()
+	defer roundTripMtx.Unlock()
+	roundTripTime = time.Now()
+	roundTripErr = err
+}
+
+// LastRoundTrip returns when the last request to ESX finished, and nil if
+// ESX answered it or the error reaching ESX. The time is zero if no request
+// was sent yet.
+func LastRoundTrip() (time.Time, error) {
+	roundTripMtx.Lock()
+	defer roundTripMtx.Unlock()
+	return roundTripTime, roundTripErr
+}
+
 // Run command Guest VM requests on ESX via vmdkops_serv.py listening on vSocket
 // *
 // * For each request:
@@ -82,15 +132,26 @@ var EsxPort int
 func (vmdkCmd EsxVmdkCmd) Run(cmd string, name string, opts map[string]string) ([]byte, error) {
 	vmdkCmd.Mtx.Lock()
 	defer vmdkCmd.Mtx.Unlock()
+	start := time.Now()
+	response, err := vmdkCmd.run(cmd, name, opts)
+	metrics.EsxRequests.Inc(cmd, metrics.Result(err))
+	metrics.EsxRequestDuration.Observe(time.Since(start).Seconds(), cmd)
+	return response, err
+}
+
+// run sends a single request, retrying on communication errors.
+// The caller holds vmdkCmd.Mtx.
+func (vmdkCmd EsxVmdkCmd) run(cmd string, name string, opts map[string]string) ([]byte, error) {
 	protocolVersion := os.Getenv("VDVS_TEST_PROTOCOL_VERSION")
 	log.Debugf("Run get request: version=%s", protocolVersion)
 	if protocolVersion == "" {
 		protocolVersion = clientProtocolVersion
 	}
 	jsonStr, err := json.Marshal(&requestToVmci{
-		Ops:     cmd,
-		Details: VolumeInfo{Name: name, Options: opts},
-		Version: protocolVersion})
+		Ops:       cmd,
+		Details:   VolumeInfo{Name: name, Options: opts},
+		Version:   protocolVersion,
+		RequestID: vmdkCmd.RequestID})
 	if err != nil {
 		return nil, fmt.Errorf("Failed to marshal json: %v", err)
 	}
@@ -106,6 +167,7 @@ func (vmdkCmd EsxVmdkCmd) Run(cmd string, name string, opts map[string]string) (
 	defer C.free(unsafe.Pointer(ans))
 
 	var ret C.be_sock_status
+	maxRetryCount, interval := getRetryPolicy()
 	for i := 0; i <= maxRetryCount; i++ {
 		ret, err = C.Vmci_GetReply(C.int(EsxPort), cmdS, beS, ans)
 		if ret == 0 {
@@ -122,34 +184,54 @@ func (vmdkCmd EsxVmdkCmd) Run(cmd string, name string, opts map[string]string) (
 		if err != nil {
 			var errno syscall.Errno
 			errno = err.(syscall.Errno)
+			metrics.EsxErrors.Inc(cmd, errnoClass(errno))
 			msg = fmt.Sprintf("Run '%s' failed: %v (errno=%d) - %s", cmd, err, int(errno), C.GoString(&ans.errBuf[0]))
 			if i < maxRetryCount {
+				metrics.EsxRetries.Inc(cmd)
 				log.Warnf(msg + " Retrying...")
-				time.Sleep(time.Second * 1)
+				time.Sleep(interval)
 				continue
 			}
 			if errno == syscall.ECONNRESET || errno == syscall.ETIMEDOUT {
 				msg += " Cannot communicate with ESX, please refer to the FAQ https://github.com/vmware/docker-volume-vsphere/wiki#faq"
 			}
 		} else {
+			metrics.EsxErrors.Inc(cmd, "internal")
 			msg = fmt.Sprintf("Internal issue: ret != 0 but errno is not set. Cancelling operation - %s ", C.GoString(&ans.errBuf[0]))
 		}
 
 		log.Warnf(msg)
+		setRoundTrip(errors.New(msg))
 		return nil, errors.New(msg)
 	}
+	setRoundTrip(nil)
 
 	response := []byte(C.GoString(ans.buf))
 	C.Vmci_FreeBuf(ans)
 
 	err = unmarshalError(response)
 	if err != nil && len(err.Error()) != 0 {
+		metrics.EsxErrors.Inc(cmd, "esx")
 		return nil, err
 	}
 	// There was no error, so return the slice containing the json response
 	return response, nil
 }
 
+// errnoClass returns the error class label of a failed request
+func errnoClass(errno syscall.Errno) string {
+	switch errno {
+	case syscall.ECONNRESET:
+		return "connection_reset"
+	case syscall.ECONNREFUSED:
+		return "connection_refused"
+	case syscall.ETIMEDOUT:
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
 func unmarshalError(str []byte) error {
 	// Unmarshalling null always succeeds
 	if string(str) == "null" {
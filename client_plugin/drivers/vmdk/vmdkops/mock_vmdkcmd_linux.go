@@ -65,6 +65,9 @@ func (mockCmd MockVmdkCmd) Run(cmd string, name string, opts map[string]string)
 		return nil, err
 	case "list":
 		return list()
+	case "list_vm_attached":
+		// loopback devices are attached on create, nothing to track
+		return []byte("[]"), nil
 	case "get":
 		return nil, get(name)
 	case "attach":
@@ -149,7 +152,7 @@ func createBlockDevice(label string, opts map[string]string) error {
 	if errFstype != nil {
 		return fmt.Errorf("Not found mkfs for %s", opts["fstype"])
 	}
-	return fs.MkfsByDevicePath(opts["fstype"], label, device)
+	return fs.MkfsWithOptions(opts["fstype"], label, device, strings.Fields(opts["mkfs-options"]))
 }
 
 func getBlockDeviceForName(name string) ([]byte, error) {
@@ -40,6 +40,16 @@ type VmdkOps struct {
 	Cmd VmdkCmdRunner // see *_vmdkcmd.go for implementations.
 }
 
+// WithRequestID returns ops sending the ID of the Docker request they
+// serve to ESX, see the reqid package
+func (v VmdkOps) WithRequestID(id string) VmdkOps {
+	if cmd, ok := v.Cmd.(EsxVmdkCmd); ok {
+		cmd.RequestID = id
+		v.Cmd = cmd
+	}
+	return v
+}
+
 // VolumeData we return to the caller
 type VolumeData struct {
 	Name       string
@@ -60,6 +70,19 @@ func (v VmdkOps) Remove(name string, opts map[string]string) error {
 	return err
 }
 
+// Resize grows a detached volume to size
+func (v VmdkOps) Resize(name string, size string) error {
+	log.Debugf("vmdkOps.Resize name=%s size=%s", name, size)
+	_, err := v.Cmd.Run("resize", name, map[string]string{"size": size})
+	return err
+}
+
+// Ping checks the ESX service is reachable
+func (v VmdkOps) Ping() error {
+	_, err := v.Cmd.Run("ping", "", nil)
+	return err
+}
+
 // RawAttach attaches a volume and returns `[]byte` representing the raw response string.
 func (v VmdkOps) RawAttach(name string, opts map[string]string) ([]byte, error) {
 	log.Debugf("vmdkOps.Attach name=%s", name)
@@ -117,6 +140,22 @@ func (v VmdkOps) List() ([]VolumeData, error) {
 	return result, nil
 }
 
+// ListVMAttached lists volumes attached to the requesting VM
+func (v VmdkOps) ListVMAttached() ([]VolumeData, error) {
+	log.Debugf("vmdkOps.ListVMAttached")
+	str, err := v.Cmd.Run("list_vm_attached", "", make(map[string]string))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []VolumeData
+	err = json.Unmarshal(str, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // Get for volume
 func (v VmdkOps) Get(name string) (map[string]interface{}, error) {
 	log.Debugf("vmdkOps.Get name=%s", name)
@@ -35,7 +35,7 @@ func main() {
 	cfg, err := config.InitConfig(config.DefaultSharedPluginConfigPath, config.DefaultSharedPluginLogPath,
 		config.SharedDriver, config.SharedDriver)
 	if err != nil {
-		log.Warning("Failed to initialize config variables for shared plugin")
+		log.WithFields(log.Fields{"err": err}).Error("Failed to initialize config variables for shared plugin ")
 		os.Exit(1)
 	}
 
@@ -51,5 +51,5 @@ func main() {
 		os.Exit(1)
 	}
 
-	plugin_server.StartServer(cfg.Driver, &driver)
+	plugin_server.StartServer(cfg.Driver, &driver, cfg)
 }
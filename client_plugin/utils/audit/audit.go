@@ -0,0 +1,111 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit keeps an append-only trail of volume lifecycle operations
+// served for Docker, a JSON record per line, in a file of its own rotated
+// by size like the plugin log.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/natefinch/lumberjack"
+)
+
+const (
+	defaultMaxSizeMb  = 100
+	defaultMaxAgeDays = 28
+)
+
+// Record of an operation
+type Record struct {
+	Time       string            `json:"time"`                 // start, RFC3339 with nanoseconds
+	RequestID  string            `json:"reqid,omitempty"`      // see the reqid package
+	Driver     string            `json:"driver"`               // the volume driver
+	Operation  string            `json:"op"`                   // create, remove, mount or unmount
+	Volume     string            `json:"volume"`               // full volume name, e.g. vol@datastore
+	Options    map[string]string `json:"options,omitempty"`    // create options
+	MountID    string            `json:"mountID,omitempty"`    // Docker mount ID of mount and unmount
+	Containers []string          `json:"containers,omitempty"` // containers using the volume
+	Result     string            `json:"result"`               // success or error
+	Error      string            `json:"error,omitempty"`      // error returned to Docker
+	DurationMs int64             `json:"durationMs"`           // time to serve the operation
+}
+
+var (
+	mtx    sync.Mutex
+	writer io.Writer // nil if auditing is off
+)
+
+// Init starts writing records to path, rotated after maxSizeMb megabytes
+// and kept for maxAgeDays days. Defaults are used for zero limits. No
+// records are written if path is empty.
+func Init(path string, maxSizeMb int, maxAgeDays int) {
+	if maxSizeMb <= 0 {
+		maxSizeMb = defaultMaxSizeMb
+	}
+	if maxAgeDays <= 0 {
+		maxAgeDays = defaultMaxAgeDays
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if closer, ok := writer.(io.Closer); ok {
+		closer.Close()
+	}
+	writer = nil
+	if path == "" {
+		return
+	}
+	writer = &lumberjack.Logger{
+		Filename: path,
+		MaxSize:  maxSizeMb,  // megabytes
+		MaxAge:   maxAgeDays, // days
+	}
+	log.WithFields(log.Fields{"path": path, "maxSizeMb": maxSizeMb,
+		"maxAgeDays": maxAgeDays}).Info("Writing audit records ")
+}
+
+// Enabled returns true if records are written
+func Enabled() bool {
+	mtx.Lock()
+	defer mtx.Unlock()
+	return writer != nil
+}
+
+// Write appends rec to the audit trail
+func Write(rec Record) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		log.WithFields(log.Fields{"record": rec, "err": err}).Error("Failed to write audit record ")
+		return
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if writer == nil {
+		return
+	}
+	if _, err = writer.Write(append(line, '\n')); err != nil {
+		log.WithFields(log.Fields{"record": rec, "err": err}).Error("Failed to write audit record ")
+	}
+}
+
+// setWriter sets the destination of records, for tests
+func setWriter(w io.Writer) {
+	mtx.Lock()
+	defer mtx.Unlock()
+	writer = w
+}
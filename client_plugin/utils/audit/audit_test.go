@@ -0,0 +1,55 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	setWriter(&buf)
+	defer setWriter(nil)
+	assert.True(t, Enabled())
+
+	Write(Record{Time: "2017-06-01T12:30:45Z", Driver: "vsphere", Operation: "create",
+		Volume: "vol1@datastore1", Options: map[string]string{"size": "10gb"},
+		Result: "success", DurationMs: 1500})
+	Write(Record{Time: "2017-06-01T12:31:00Z", Driver: "vsphere", Operation: "mount",
+		Volume: "vol1@datastore1", MountID: "4d3c", Containers: []string{"a1b2"},
+		Result: "error", Error: "Failed to attach", DurationMs: 10})
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+	assert.Equal(t, `{"time":"2017-06-01T12:30:45Z","driver":"vsphere","op":"create",`+
+		`"volume":"vol1@datastore1","options":{"size":"10gb"},"result":"success","durationMs":1500}`,
+		string(lines[0]))
+
+	var rec Record
+	assert.Nil(t, json.Unmarshal(lines[1], &rec))
+	assert.Equal(t, "4d3c", rec.MountID)
+	assert.Equal(t, []string{"a1b2"}, rec.Containers)
+	assert.Equal(t, "Failed to attach", rec.Error)
+}
+
+func TestDisabled(t *testing.T) {
+	setWriter(nil)
+	assert.False(t, Enabled())
+	Write(Record{Operation: "create"})
+}
@@ -18,14 +18,13 @@ package config
 // See default-config.json at the root of the project.
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/natefinch/lumberjack"
 	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/log_formatter"
-	"io/ioutil"
 	"os"
+	"reflect"
 	"runtime"
 )
 
@@ -45,6 +44,9 @@ const (
 	// SharedDriver is a shared plugin driver
 	SharedDriver = "shared"
 
+	// ClassOption is the create option selecting a class of options
+	ClassOption = "class"
+
 	// defaultPort is the default ESX service port.
 	defaultPort = 1019
 
@@ -52,6 +54,13 @@ const (
 	defaultMaxLogSizeMb  = 100
 	defaultMaxLogAgeDays = 28
 	defaultLogLevel      = "info"
+
+	// defaultTrimIntervalHours - period of trims of volumes with trim=periodic
+	defaultTrimIntervalHours = 24
+
+	// Default retry policy of requests to the ESX service
+	defaultEsxRetryCount      = 5
+	defaultEsxRetryIntervalMs = 1000
 )
 
 // Config stores the configuration for the plugin
@@ -61,37 +70,83 @@ type Config struct {
 	MaxLogSizeMb  int    `json:",omitempty"`
 	MaxLogAgeDays int    `json:",omitempty"`
 	LogLevel      string `json:",omitempty"`
+	LogFormat     string `json:",omitempty"`
 	Target        string `json:",omitempty"`
 	Project       string `json:",omitempty"`
 	Host          string `json:",omitempty"`
 	Port          int    `json:",omitempty"`
 	UseMockEsx    bool   `json:",omitempty"`
+
+	// OrphanDetachDryRun only logs volumes attached to the VM but not
+	// used by Docker, instead of detaching them on plugin start.
+	OrphanDetachDryRun bool `json:",omitempty"`
+
+	// Escalation when a volume filesystem stays busy on unmount. Force
+	// tries a forced unmount, Lazy detaches the filesystem from the mount
+	// tree. The volume is never detached while its filesystem is in use.
+	UnmountForce bool `json:",omitempty"`
+	UnmountLazy  bool `json:",omitempty"`
+
+	// TrimIntervalHours is the period of filesystem trims for volumes
+	// created with trim=periodic.
+	TrimIntervalHours int `json:",omitempty"`
+
+	// Keys of volumes created with encrypt=luks are read from LuksKeyFile,
+	// a key file or a directory of key files named by key ID, or printed
+	// by LuksKeyCommand, run with the key ID as the last argument.
+	// LuksKeyID and LuksCipher are used unless given at volume create.
+	LuksKeyFile    string `json:",omitempty"`
+	LuksKeyCommand string `json:",omitempty"`
+	LuksKeyID      string `json:",omitempty"`
+	LuksCipher     string `json:",omitempty"`
+
+	// Requests which fail to reach the ESX service are retried
+	// EsxRetryCount times, EsxRetryIntervalMs apart.
+	EsxRetryCount      int `json:",omitempty"`
+	EsxRetryIntervalMs int `json:",omitempty"`
+
+	// AdminSock is the unix sock of the admin interface, see
+	// plugin_server.AdminServer. The admin interface is off if empty.
+	AdminSock string `json:",omitempty"`
+
+	// MetricsAddress is the TCP address, e.g. ":9115", serving
+	// Prometheus metrics at /metrics. Metrics are not served if empty.
+	MetricsAddress string `json:",omitempty"`
+
+	// AuditLogPath is the file of audit records of volume operations,
+	// rotated after AuditMaxSizeMb and kept for AuditMaxAgeDays, see the
+	// audit package. No records are written if empty.
+	AuditLogPath    string `json:",omitempty"`
+	AuditMaxSizeMb  int    `json:",omitempty"`
+	AuditMaxAgeDays int    `json:",omitempty"`
+
+	// CreateOptions are the create options of volumes created without
+	// them, e.g. {"size": "10gb"}. Classes are named sets of create
+	// options, selected with -o class=<name>. Options given at create
+	// take precedence over the class, and the class over CreateOptions.
+	CreateOptions map[string]string            `json:",omitempty"`
+	Classes       map[string]map[string]string `json:",omitempty"`
 }
 
 // Load the configuration from a file and return a Config.
-func load(path string) (Config, error) {
-	jsonBlob, err := ioutil.ReadFile(path)
+func Load(path string) (Config, error) {
+	c, inFile, err := readFile(path)
 	if err != nil {
 		return Config{}, err
 	}
-	var config Config
-	if err := json.Unmarshal(jsonBlob, &config); err != nil {
-		return Config{}, err
-	}
-	setDefaults(&config)
-	return config, nil
+	setDefaults(&c, inFile)
+	return c, nil
 }
 
-// setDefaults for any config setting that is at its `bottom`
-func setDefaults(config *Config) {
-	if config.MaxLogSizeMb == 0 {
-		config.MaxLogSizeMb = defaultMaxLogSizeMb
-	}
-	if config.MaxLogAgeDays == 0 {
-		config.MaxLogAgeDays = defaultMaxLogAgeDays
-	}
-	if config.LogLevel == "" {
-		config.LogLevel = defaultLogLevel
+// setDefaults for any config setting not taken from the file, inFile
+// holds the fields in the file
+func setDefaults(c *Config, inFile map[string]bool) {
+	value := reflect.ValueOf(c).Elem()
+	defaults := reflect.ValueOf(defaultConfig(c.Driver, ""))
+	for i := 0; i < value.NumField(); i++ {
+		if !fromFile(value.Type().Field(i).Name, value.Field(i), inFile) {
+			value.Field(i).Set(defaults.Field(i))
+		}
 	}
 }
 
@@ -99,89 +154,93 @@ func setDefaults(config *Config) {
 // returns True if using defaults,  False if using config file
 func LogInit(logLevel *string, logFile *string, defaultLogFile string, configFile *string) bool {
 	usingConfigDefaults := false
-	c, err := load(*configFile)
+	c, err := Load(*configFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			usingConfigDefaults = true // no .conf file, so using defaults
 			c = Config{}
-			setDefaults(&c)
+			setDefaults(&c, nil)
 		} else {
 			panic(fmt.Sprintf("Failed to load config file %s: %v",
 				*configFile, err))
 		}
 	}
 
-	path := c.LogPath
-	if path == "" {
-		path = defaultLogFile
-	}
-
 	if logFile != nil {
-		path = *logFile
+		c.LogPath = *logFile
 	}
-	log.SetOutput(&lumberjack.Logger{
-		Filename: path,
-		MaxSize:  c.MaxLogSizeMb,  // megabytes
-		MaxAge:   c.MaxLogAgeDays, // days
-	})
-
 	if *logLevel == "" {
 		*logLevel = c.LogLevel
 	}
+	c.LogLevel = *logLevel
 
-	level, err := log.ParseLevel(*logLevel)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to parse log level: %v", err))
+	reloadState.defaultLogPath = defaultLogFile
+	if err = SetupLog(c); err != nil {
+		panic(err.Error())
 	}
 
-	log.SetFormatter(new(log_formatter.VmwareFormatter))
-	log.SetLevel(level)
-
 	if usingConfigDefaults {
 		log.Info("No config file found. Using defaults.")
 	}
 	return usingConfigDefaults
 }
 
-// InitConfig set up driver specific options
-func InitConfig(defaultConfigPath string, defaultLogPath string, defaultDriver string,
-	defaultWindowsDriver string) (Config, error) {
-	// Get options from ENV (where available), and from command line.
-	// ENV takes precedence, so we can modify it in Docker plugin install
-	logEnv := os.Getenv("VDVS_LOG_LEVEL")
-	logLevel := &logEnv
-	if *logLevel == "" {
-		logLevel = flag.String("log_level", "", "Logging Level")
+// SetupLog sends the log to the log file of c, rotated by size and age,
+// and sets the log level and format
+func SetupLog(c Config) error {
+	level, err := log.ParseLevel(c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("Failed to parse log level: %v", err)
+	}
+	formatter, err := log_formatter.NewFormatter(c.LogFormat)
+	if err != nil {
+		return fmt.Errorf("Failed to set log format: %v", err)
 	}
-	configFile := flag.String("config", defaultConfigPath, "Configuration file path")
-	driverName := flag.String("driver", "", "Volume driver")
 
-	// Photon driver options
-	targetURL := flag.String("target", "", "Photon controller URL")
-	projectID := flag.String("project", "", "Project ID of the docker host")
-	vmID := flag.String("host", "", "ID of docker host")
+	path := c.LogPath
+	if path == "" {
+		path = reloadState.defaultLogPath
+	}
+	logFile := &lumberjack.Logger{
+		Filename: path,
+		MaxSize:  c.MaxLogSizeMb,  // megabytes
+		MaxAge:   c.MaxLogAgeDays, // days
+	}
+	log.SetOutput(logFile)
+	if reloadState.logFile != nil {
+		reloadState.logFile.Close()
+	}
+	reloadState.logFile = logFile
 
-	// vSphere driver options
-	port := flag.Int("port", defaultPort, "Default port to connect to ESX service")
-	useMockEsx := flag.Bool("mock_esx", false, "Mock the ESX service")
+	log.SetFormatter(formatter)
+	log.SetLevel(level)
+	return nil
+}
 
+// InitConfig set up driver specific options. Each field is taken from
+// the config file, overridden by its VDVS_ environment variable and its
+// flag. All problems of the configuration are returned in the error.
+func InitConfig(defaultConfigPath string, defaultLogPath string, defaultDriver string,
+	defaultWindowsDriver string) (Config, error) {
+	configFile := flag.String("config", defaultConfigPath, "Configuration file path")
+	printConfig := flag.Bool("print-config", false, "Print the configuration and the source of each value, and exit")
+	addFlags(flag.CommandLine, defaultConfig(defaultDriver, defaultLogPath))
 	flag.Parse()
 
-	// Load the configuration if one was provided.
-	c, err := load(*configFile)
-	if err != nil {
-		log.Warningf("Failed to load config file %s: %v", *configFile, err)
+	var errs configErrors
+	usingConfigDefaults := false
+	file, inFile, err := readFile(*configFile)
+	if fileErrs, ok := err.(configErrors); ok {
+		errs = append(errs, fileErrs...)
+	} else if os.IsNotExist(err) {
+		usingConfigDefaults = true
+	} else if err != nil {
+		errs = append(errs, err.Error())
 	}
 
-	LogInit(logLevel, nil, defaultLogPath, configFile)
-
-	// If no driver provided on the command line, use the one in the
-	// config file or the default.
-	if *driverName != "" {
-		c.Driver = *driverName
-	} else if c.Driver == "" {
-		c.Driver = defaultDriver
-	}
+	l := newLayers(flag.CommandLine, os.Getenv, defaultDriver, defaultLogPath)
+	c, sources, resolveErrs := l.resolve(file, inFile)
+	errs = append(errs, resolveErrs...)
 
 	// The windows plugin only supports the vsphere driver.
 	if runtime.GOOS == "windows" && c.Driver != defaultWindowsDriver {
@@ -191,50 +250,61 @@ func InitConfig(defaultConfigPath string, defaultLogPath string, defaultDriver s
 		fmt.Println(msg)
 		c.Driver = defaultWindowsDriver
 	}
+	errs = append(errs, validate(c)...)
+
+	if *printConfig {
+		PrintConfig(os.Stdout, c, sources)
+		if len(errs) > 0 {
+			fmt.Fprintln(os.Stderr, errs.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+
+	reloadState.configFile = *configFile
+	reloadState.file = file
+	reloadState.layers = l
+	reloadState.defaultLogPath = defaultLogPath
+	if err = SetupLog(c); err != nil {
+		return c, err
+	}
+	if usingConfigDefaults {
+		log.Info("No config file found. Using defaults.")
+	}
 
 	log.WithFields(log.Fields{
 		"driver":    c.Driver,
-		"log_level": *logLevel,
+		"log_level": c.LogLevel,
 		"config":    *configFile,
+		"adminSock": c.AdminSock,
+		"metrics":   c.MetricsAddress,
+		"auditLog":  c.AuditLogPath,
 	}).Info("Starting plugin ")
 
-	if c.Driver == PhotonDriver && err == nil {
-		if *targetURL != "" {
-			c.Target = *targetURL
-		}
-		if *projectID != "" {
-			c.Project = *projectID
-		}
-		if *vmID != "" {
-			c.Host = *vmID
-		}
-
+	if c.Driver == PhotonDriver {
 		log.WithFields(log.Fields{
 			"target":  c.Target,
 			"project": c.Project,
 			"host":    c.Host}).Info("Plugin options - ")
-
-		if c.Target == "" || c.Project == "" || c.Host == "" {
-			log.Warning("Invalid options specified for target/project/host")
-			fmt.Printf("Invalid options specified for target - %s project - %s host - %s. Exiting.\n",
-				c.Target, c.Project, c.Host)
-			os.Exit(1)
-		}
 	} else if c.Driver == VSphereDriver || c.Driver == VMDKDriver {
 		if c.Driver == VMDKDriver {
 			log.Warning("Using deprecated \"vmdk\" driver, use \"vsphere\" driver instead - continuing...")
 			c.Driver = VSphereDriver
 		}
 
-		c.Port = *port
-		c.UseMockEsx = *useMockEsx
-
 		log.WithFields(log.Fields{
-			"port":       c.UseMockEsx,
-			"useMockEsx": c.UseMockEsx}).Info("Plugin options - ")
-
+			"port":         c.Port,
+			"useMockEsx":   c.UseMockEsx,
+			"orphanDryRun": c.OrphanDetachDryRun,
+			"unmountForce": c.UnmountForce,
+			"unmountLazy":  c.UnmountLazy,
+			"trimInterval": c.TrimIntervalHours,
+			"luksKeyFile":  c.LuksKeyFile,
+			"luksKeyCmd":   c.LuksKeyCommand}).Info("Plugin options - ")
 	}
 
 	return c, nil
-
 }
@@ -26,6 +26,11 @@ const (
 	// DefaultSharedPluginLogPath is the default location of log (trace) file for shared plugin
 	DefaultSharedPluginLogPath = "/var/log/vsphere-shared.log"
 
+	// DefaultVMDKPluginAdminSock is the default location of the admin sock of vmdk plugin
+	DefaultVMDKPluginAdminSock = "/run/docker-volume-vsphere/admin.sock"
+	// DefaultSharedPluginAdminSock is the default location of the admin sock of shared plugin
+	DefaultSharedPluginAdminSock = "/run/vsphere-shared/admin.sock"
+
 	// MountRoot is the path where VMDK and photon volumes are mounted
 	MountRoot = "/mnt/vmdk"
 )
@@ -25,6 +25,10 @@ var (
 	// DefaultVMDKPluginLogPath is the default location of the log (trace) file.
 	DefaultVMDKPluginLogPath = filepath.Join(os.Getenv("LOCALAPPDATA"), "docker-volume-vsphere", "logs", "docker-volume-vsphere.log")
 
+	// The admin interface is not served on Windows
+	DefaultVMDKPluginAdminSock   = ""
+	DefaultSharedPluginAdminSock = ""
+
 	// VMDK volumes are mounted here
 	MountRoot = filepath.Join(os.Getenv("LOCALAPPDATA"), "docker-volume-vsphere", "mounts")
 )
@@ -0,0 +1,391 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// Layers of the plugin configuration. Each Config field is taken from, in
+// increasing precedence, the defaults, the configuration file, the VDVS_
+// environment variable and the command line flag of the field. A value
+// set by a layer is kept even if zero or empty, validate rejects it where
+// it is meaningless. Empty environment variables are ignored, and so are a
+// zero MaxLogSizeMb and an empty LogLevel in the file.
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/log_formatter"
+)
+
+const (
+	// envPrefix of the environment variables of Config fields, followed
+	// by the flag name in upper case, e.g. VDVS_LOG_LEVEL
+	envPrefix = "VDVS_"
+
+	// Sources of configuration values
+	sourceDefault = "default"
+	sourceFile    = "file"
+)
+
+// options - the command line flag of each Config field
+var options = []struct {
+	field string
+	flag  string
+	usage string
+}{
+	{"Driver", "driver", "Volume driver"},
+	{"LogPath", "log_path", "Log file path"},
+	{"MaxLogSizeMb", "max_log_size_mb", "Max. size of the log file in MB before it is rotated"},
+	{"MaxLogAgeDays", "max_log_age_days", "Days to retain rotated log files"},
+	{"LogLevel", "log_level", "Logging Level"},
+	{"LogFormat", "log_format", "Log format - vmware, json or logfmt"},
+	{"Target", "target", "Photon controller URL"},
+	{"Project", "project", "Project ID of the docker host"},
+	{"Host", "host", "ID of docker host"},
+	{"Port", "port", "Default port to connect to ESX service"},
+	{"UseMockEsx", "mock_esx", "Mock the ESX service"},
+	{"OrphanDetachDryRun", "orphan_dry_run", "Only log attached but unused volumes, don't detach them"},
+	{"UnmountForce", "unmount_force", "Force unmount of busy volumes"},
+	{"UnmountLazy", "unmount_lazy", "Lazy unmount of busy volumes as the last resort"},
+	{"TrimIntervalHours", "trim_interval_hours", "Period of trims of volumes with trim=periodic"},
+	{"LuksKeyFile", "luks_key_file", "Key file, or directory of key files, of encrypted volumes"},
+	{"LuksKeyCommand", "luks_key_command", "Command printing the key of encrypted volumes"},
+	{"LuksKeyID", "luks_key_id", "Key ID of encrypted volumes created without one"},
+	{"LuksCipher", "luks_cipher", "Cipher of encrypted volumes created without one"},
+	{"EsxRetryCount", "esx_retry_count", "Attempts of ESX requests which fail to connect"},
+	{"EsxRetryIntervalMs", "esx_retry_interval_ms", "Milliseconds between attempts of ESX requests"},
+	{"AdminSock", "admin_sock", "Unix sock of the admin interface"},
+	{"MetricsAddress", "metrics_address", "TCP address serving Prometheus metrics, e.g. :9115"},
+	{"AuditLogPath", "audit_log", "File of audit records of volume operations"},
+	{"AuditMaxSizeMb", "audit_max_size_mb", "Max. size of the audit file in MB before it is rotated"},
+	{"AuditMaxAgeDays", "audit_max_age_days", "Days to retain rotated audit files"},
+	{"CreateOptions", "create_options", "Create options of volumes created without them"},
+	{"Classes", "classes", "Named classes of create options, selected with -o class=<name>"},
+}
+
+// configErrors - all problems found in a configuration
+type configErrors []string
+
+func (e configErrors) Error() string {
+	return "Invalid configuration: " + strings.Join(e, "; ")
+}
+
+// layers - the defaults of the plugin, and the environment variables and
+// flags given to it, by field name
+type layers struct {
+	defaultDriver  string
+	defaultLogPath string
+	env            map[string]string
+	flags          map[string]string
+}
+
+// defaultConfig returns the defaults of a plugin running driver
+func defaultConfig(driver string, logPath string) Config {
+	c := Config{
+		Driver:             driver,
+		LogPath:            logPath,
+		MaxLogSizeMb:       defaultMaxLogSizeMb,
+		MaxLogAgeDays:      defaultMaxLogAgeDays,
+		LogLevel:           defaultLogLevel,
+		Port:               defaultPort,
+		TrimIntervalHours:  defaultTrimIntervalHours,
+		EsxRetryCount:      defaultEsxRetryCount,
+		EsxRetryIntervalMs: defaultEsxRetryIntervalMs,
+		AdminSock:          DefaultVMDKPluginAdminSock,
+	}
+	if driver == SharedDriver {
+		c.AdminSock = DefaultSharedPluginAdminSock
+	}
+	return c
+}
+
+// addFlags defines the flags of all Config fields in fs, showing the
+// values of d as their defaults
+func addFlags(fs *flag.FlagSet, d Config) {
+	value := reflect.ValueOf(d)
+	for _, o := range options {
+		field := value.FieldByName(o.field)
+		switch field.Kind() {
+		case reflect.Bool:
+			fs.Bool(o.flag, field.Bool(), o.usage)
+		case reflect.Int:
+			fs.Int(o.flag, int(field.Int()), o.usage)
+		case reflect.String:
+			fs.String(o.flag, field.String(), o.usage)
+		default:
+			fs.String(o.flag, "", o.usage+" (JSON)")
+		}
+	}
+}
+
+// newLayers collects the flags set in the parsed fs, and the non empty
+// environment variables of Config fields
+func newLayers(fs *flag.FlagSet, getenv func(string) string, defaultDriver string, defaultLogPath string) *layers {
+	l := &layers{
+		defaultDriver:  defaultDriver,
+		defaultLogPath: defaultLogPath,
+		env:            make(map[string]string),
+		flags:          make(map[string]string),
+	}
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+	for _, o := range options {
+		if value, set := given[o.flag]; set {
+			l.flags[o.field] = value
+		}
+		if value := getenv(envName(o.flag)); value != "" {
+			l.env[o.field] = value
+		}
+	}
+	return l
+}
+
+// envName returns the environment variable of the flag name
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(flagName)
+}
+
+// flagName returns the flag of the Config field name
+func flagName(field string) string {
+	for _, o := range options {
+		if o.field == field {
+			return o.flag
+		}
+	}
+	return ""
+}
+
+// readFile reads a configuration file. The fields found in the file are
+// returned with the Config. Unknown keys and values of the wrong type are
+// returned together as configErrors.
+func readFile(path string) (Config, map[string]bool, error) {
+	var c Config
+	jsonBlob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err = json.Unmarshal(jsonBlob, &keys); err != nil {
+		return c, nil, fmt.Errorf("Failed to parse config file %s: %v", path, err)
+	}
+
+	// Keys match fields regardless of case, as in json.Unmarshal
+	var errs configErrors
+	inFile := make(map[string]bool)
+	value := reflect.ValueOf(&c).Elem()
+	for key, raw := range keys {
+		field, found := value.Type().FieldByNameFunc(func(name string) bool {
+			return strings.EqualFold(name, key)
+		})
+		if !found {
+			errs = append(errs, fmt.Sprintf("unknown key %q in %s", key, path))
+			continue
+		}
+		if err = json.Unmarshal(raw, value.FieldByIndex(field.Index).Addr().Interface()); err != nil {
+			errs = append(errs, fmt.Sprintf("invalid value %s of %s in %s", raw, key, path))
+			continue
+		}
+		inFile[field.Name] = true
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return c, inFile, errs
+	}
+	return c, inFile, nil
+}
+
+// resolve layers file, with the fields inFile, between the defaults and
+// the environment and flags. The source of each field is returned with
+// the Config, and values of the wrong type as configErrors.
+func (l *layers) resolve(file Config, inFile map[string]bool) (Config, map[string]string, configErrors) {
+	var c Config
+	var errs configErrors
+	sources := make(map[string]string)
+	value := reflect.ValueOf(&c).Elem()
+	fileValue := reflect.ValueOf(file)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if fromFile(name, fileValue.Field(i), inFile) {
+			value.Field(i).Set(fileValue.Field(i))
+			sources[name] = sourceFile
+		}
+		if raw, set := l.env[name]; set {
+			source := "env " + envName(flagName(name))
+			if err := setField(value.Field(i), raw); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q of %s: %v", raw, source, err))
+			} else {
+				sources[name] = source
+			}
+		}
+		if raw, set := l.flags[name]; set {
+			source := "flag -" + flagName(name)
+			if err := setField(value.Field(i), raw); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid value %q of %s: %v", raw, source, err))
+			} else {
+				sources[name] = source
+			}
+		}
+	}
+
+	// Defaults of the other fields depend on the driver. Fields set by a
+	// layer keep their value, even if zero.
+	if sources["Driver"] == "" {
+		c.Driver = l.defaultDriver
+		sources["Driver"] = sourceDefault
+	}
+	defaults := reflect.ValueOf(defaultConfig(c.Driver, l.defaultLogPath))
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if sources[name] == "" {
+			value.Field(i).Set(defaults.Field(i))
+			sources[name] = sourceDefault
+		}
+	}
+	return c, sources, errs
+}
+
+// setField sets field to the value of an environment variable or flag
+func setField(field reflect.Value, raw string) error {
+	switch field.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("not a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		field.SetInt(int64(n))
+	case reflect.String:
+		field.SetString(raw)
+	default:
+		value := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(raw), value.Interface()); err != nil {
+			return err
+		}
+		field.Set(value.Elem())
+	}
+	return nil
+}
+
+// zeroDefaultFields - fields of Config whose zero or empty value in the
+// file stands for the default, as in configuration files of older releases
+var zeroDefaultFields = map[string]bool{
+	"MaxLogSizeMb": true,
+	"LogLevel":     true,
+}
+
+// fromFile checks if the field name is taken from the file, where it has
+// the value field. inFile holds the fields in the file.
+func fromFile(name string, field reflect.Value, inFile map[string]bool) bool {
+	if !inFile[name] {
+		return false
+	}
+	zero := reflect.Zero(field.Type()).Interface()
+	return !zeroDefaultFields[name] || !reflect.DeepEqual(field.Interface(), zero)
+}
+
+// positiveFields - fields of Config which are meaningless if zero
+var positiveFields = map[string]bool{
+	"MaxLogSizeMb":       true,
+	"Port":               true,
+	"TrimIntervalHours":  true,
+	"EsxRetryIntervalMs": true,
+}
+
+// validate returns all problems of c, nil if there are none
+func validate(c Config) configErrors {
+	var errs configErrors
+	switch c.Driver {
+	case PhotonDriver, VSphereDriver, VMDKDriver, SharedDriver:
+	default:
+		errs = append(errs, fmt.Sprintf("unknown Driver %q", c.Driver))
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid LogLevel %q", c.LogLevel))
+	}
+	if _, err := log_formatter.NewFormatter(c.LogFormat); err != nil {
+		errs = append(errs, fmt.Sprintf("invalid LogFormat %q", c.LogFormat))
+	}
+
+	if c.LogPath == "" {
+		errs = append(errs, "LogPath must be set")
+	}
+
+	value := reflect.ValueOf(c)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		if value.Field(i).Kind() != reflect.Int {
+			continue
+		}
+		if positiveFields[name] && value.Field(i).Int() <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be positive", name))
+		} else if value.Field(i).Int() < 0 {
+			errs = append(errs, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+	if c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("invalid Port %d", c.Port))
+	}
+
+	if c.Driver == PhotonDriver {
+		if c.Target == "" || c.Project == "" || c.Host == "" {
+			errs = append(errs, fmt.Sprintf("photon driver needs Target, Project and Host, got %q, %q and %q",
+				c.Target, c.Project, c.Host))
+		}
+	}
+	for option := range c.CreateOptions {
+		if option == ClassOption {
+			errs = append(errs, fmt.Sprintf("CreateOptions can't select a %s", ClassOption))
+		}
+	}
+	for name, class := range c.Classes {
+		if _, found := class[ClassOption]; found || name == "" {
+			errs = append(errs, fmt.Sprintf("invalid class %q, classes need a name and can't select a %s",
+				name, ClassOption))
+		}
+	}
+	if c.LuksKeyFile != "" && c.LuksKeyCommand != "" {
+		errs = append(errs, "configure either LuksKeyFile or LuksKeyCommand, not both")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// PrintConfig writes each field of c with its value and source to w
+func PrintConfig(w io.Writer, c Config, sources map[string]string) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Field\tValue\tSource")
+	value := reflect.ValueOf(c)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		printed, _ := json.Marshal(value.Field(i).Interface())
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, printed, sources[name])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// Tests of the layers of the configuration
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "vdvs-config")
+	assert.Nil(t, err)
+	f.WriteString(content)
+	f.Close()
+	return f.Name()
+}
+
+func testLayers(t *testing.T, args []string, env map[string]string) *layers {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addFlags(fs, defaultConfig(VSphereDriver, "/var/log/test.log"))
+	assert.Nil(t, fs.Parse(args))
+	return newLayers(fs, func(name string) string { return env[name] }, VSphereDriver, "/var/log/test.log")
+}
+
+func TestOptionsCoverConfig(t *testing.T) {
+	fields := reflect.TypeOf(Config{})
+	assert.Equal(t, fields.NumField(), len(options))
+	for _, o := range options {
+		_, found := fields.FieldByName(o.field)
+		assert.True(t, found, o.field)
+	}
+}
+
+func TestResolvePrecedence(t *testing.T) {
+	path := writeConfig(t, `{"LogLevel": "warning", "Port": 1020, "MaxLogAgeDays": 7, "unmountforce": true}`)
+	defer os.Remove(path)
+	file, inFile, err := readFile(path)
+	assert.Nil(t, err)
+
+	l := testLayers(t, []string{"-port", "1022", "-unmount_force=false"},
+		map[string]string{"VDVS_LOG_LEVEL": "debug", "VDVS_PORT": "1021", "VDVS_LUKS_CIPHER": "aes"})
+	c, sources, errs := l.resolve(file, inFile)
+	assert.Nil(t, errs)
+
+	assert.Equal(t, VSphereDriver, c.Driver)
+	assert.Equal(t, sourceDefault, sources["Driver"])
+	assert.Equal(t, "/var/log/test.log", c.LogPath)
+	assert.Equal(t, 7, c.MaxLogAgeDays)
+	assert.Equal(t, sourceFile, sources["MaxLogAgeDays"])
+	assert.Equal(t, "debug", c.LogLevel)
+	assert.Equal(t, "env VDVS_LOG_LEVEL", sources["LogLevel"])
+	assert.Equal(t, 1022, c.Port)
+	assert.Equal(t, "flag -port", sources["Port"])
+	assert.False(t, c.UnmountForce)
+	assert.Equal(t, "flag -unmount_force", sources["UnmountForce"])
+	assert.Equal(t, "aes", c.LuksCipher)
+	assert.Equal(t, DefaultVMDKPluginAdminSock, c.AdminSock)
+}
+
+func TestResolveDefaultsOfDriver(t *testing.T) {
+	l := testLayers(t, []string{"-driver", SharedDriver}, nil)
+	c, sources, errs := l.resolve(Config{}, nil)
+	assert.Nil(t, errs)
+	assert.Equal(t, DefaultSharedPluginAdminSock, c.AdminSock)
+	assert.Equal(t, sourceDefault, sources["AdminSock"])
+	assert.Equal(t, defaultEsxRetryCount, c.EsxRetryCount)
+}
+
+func TestResolveInvalidValues(t *testing.T) {
+	l := testLayers(t, nil, map[string]string{"VDVS_PORT": "esx", "VDVS_MOCK_ESX": "maybe"})
+	_, _, errs := l.resolve(Config{}, nil)
+	assert.Equal(t, 2, len(errs))
+}
+
+func TestReadFileErrors(t *testing.T) {
+	path := writeConfig(t, `{"LogLevel": "info", "LogLevle": "debug", "Port": "1019", "Colour": 1}`)
+	defer os.Remove(path)
+	_, inFile, err := readFile(path)
+	errs, ok := err.(configErrors)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(errs))
+	assert.True(t, inFile["LogLevel"])
+
+	path = writeConfig(t, `{"LogLevel": `)
+	defer os.Remove(path)
+	_, _, err = readFile(path)
+	_, ok = err.(configErrors)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
+
+func TestValidate(t *testing.T) {
+	c := defaultConfig(PhotonDriver, "/var/log/test.log")
+	c.LogLevel = "loud"
+	c.Port = -1
+	c.LuksKeyFile = "/etc/keys"
+	c.LuksKeyCommand = "get-key"
+	errs := validate(c)
+	// log level, port, photon options, key file and command
+	assert.Equal(t, 4, len(errs))
+
+	assert.Nil(t, validate(defaultConfig(VSphereDriver, "/var/log/test.log")))
+	// log path
+	assert.Equal(t, 1, len(validate(defaultConfig(VSphereDriver, ""))))
+}
+
+func TestResolveExplicitZero(t *testing.T) {
+	path := writeConfig(t, `{"EsxRetryCount": 0, "MaxLogAgeDays": 0, "AdminSock": "",
+		"MaxLogSizeMb": 0, "LogLevel": ""}`)
+	defer os.Remove(path)
+	file, inFile, err := readFile(path)
+	assert.Nil(t, err)
+
+	l := testLayers(t, []string{"-port", "0"}, nil)
+	c, sources, errs := l.resolve(file, inFile)
+	assert.Nil(t, errs)
+	assert.Equal(t, 0, c.EsxRetryCount)
+	assert.Equal(t, sourceFile, sources["EsxRetryCount"])
+	assert.Equal(t, 0, c.Port)
+	assert.Equal(t, "flag -port", sources["Port"])
+	assert.Equal(t, "", c.AdminSock, "the admin interface is off")
+	assert.Equal(t, sourceFile, sources["AdminSock"])
+	assert.Equal(t, 0, c.MaxLogAgeDays)
+	assert.Equal(t, defaultEsxRetryIntervalMs, c.EsxRetryIntervalMs)
+
+	// zero log size and empty log level in the file stand for the defaults
+	assert.Equal(t, defaultMaxLogSizeMb, c.MaxLogSizeMb)
+	assert.Equal(t, sourceDefault, sources["MaxLogSizeMb"])
+	assert.Equal(t, defaultLogLevel, c.LogLevel)
+	assert.Equal(t, sourceDefault, sources["LogLevel"])
+
+	// only the port is invalid
+	assert.Equal(t, []string{"Port must be positive"}, []string(validate(c)))
+}
+
+func TestPrintConfig(t *testing.T) {
+	var out bytes.Buffer
+	c := defaultConfig(VSphereDriver, "")
+	PrintConfig(&out, c, map[string]string{"Port": "flag -port"})
+	assert.Contains(t, out.String(), "Port")
+	assert.Contains(t, out.String(), "1019")
+	assert.Contains(t, out.String(), "flag -port")
+}
+
+func TestResolveCreateOptions(t *testing.T) {
+	path := writeConfig(t, `{"CreateOptions": {"size": "10gb"}, "Classes": {"gold": {"size": "50gb", "fstype": "xfs"}}}`)
+	defer os.Remove(path)
+	file, inFile, err := readFile(path)
+	assert.Nil(t, err)
+
+	l := testLayers(t, nil, map[string]string{"VDVS_CREATE_OPTIONS": `{"size": "20gb", "diskformat": "thin"}`})
+	c, sources, errs := l.resolve(file, inFile)
+	assert.Nil(t, errs)
+	assert.Equal(t, map[string]string{"size": "20gb", "diskformat": "thin"}, c.CreateOptions)
+	assert.Equal(t, "env VDVS_CREATE_OPTIONS", sources["CreateOptions"])
+	assert.Equal(t, "xfs", c.Classes["gold"]["fstype"])
+	assert.Equal(t, sourceFile, sources["Classes"])
+
+	l = testLayers(t, []string{"-classes", `{"gold": "xfs"}`}, nil)
+	_, _, errs = l.resolve(file, inFile)
+	assert.Equal(t, 1, len(errs))
+}
+
+func TestValidateClasses(t *testing.T) {
+	c := defaultConfig(VSphereDriver, "/var/log/test.log")
+	c.CreateOptions = map[string]string{ClassOption: "gold"}
+	c.Classes = map[string]map[string]string{
+		"gold":   {"size": "50gb"},
+		"silver": {ClassOption: "gold"},
+	}
+	assert.Equal(t, 2, len(validate(c)))
+}
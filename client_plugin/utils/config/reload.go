@@ -0,0 +1,107 @@
+// Copyright 2016-2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+// Reload of the configuration file while the plugin runs, e.g. on SIGHUP.
+// Only runtime fields are reloaded, changes of other fields are logged
+// and take effect when the plugin restarts.
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/natefinch/lumberjack"
+)
+
+// runtimeFields - fields of Config which can change while the plugin runs
+var runtimeFields = map[string]bool{
+	"LogLevel":           true,
+	"LogFormat":          true,
+	"LogPath":            true,
+	"MaxLogSizeMb":       true,
+	"MaxLogAgeDays":      true,
+	"EsxRetryCount":      true,
+	"EsxRetryIntervalMs": true,
+	"AdminSock":          true,
+	"MetricsAddress":     true,
+	"AuditLogPath":       true,
+	"AuditMaxSizeMb":     true,
+	"AuditMaxAgeDays":    true,
+	"CreateOptions":      true,
+	"Classes":            true,
+}
+
+// reloadState - what reloads need to know about the plugin start
+var reloadState struct {
+	configFile     string             // the configuration file
+	file           Config             // the file as last applied
+	layers         *layers            // defaults, environment and flags of the plugin
+	defaultLogPath string             // log file if the config has none
+	logFile        *lumberjack.Logger // the current log file
+}
+
+// Reload reads the configuration file again and returns current with
+// the runtime fields of the file, environment variables and flags still
+// taking precedence. Changes of other fields are logged and ignored.
+// current is returned with an error if the file can't be loaded or the
+// configuration is invalid.
+func Reload(current Config) (Config, error) {
+	if reloadState.layers == nil {
+		return current, fmt.Errorf("Configuration was not loaded on plugin start")
+	}
+	file, inFile, err := readFile(reloadState.configFile)
+	if err != nil && !os.IsNotExist(err) {
+		return current, fmt.Errorf("Failed to load config file %s: %v", reloadState.configFile, err)
+	}
+	resolved, _, errs := reloadState.layers.resolve(file, inFile)
+	resolved.Driver = current.Driver
+	if errs = append(errs, validate(resolved)...); len(errs) > 0 {
+		return current, errs
+	}
+
+	next := current
+	nextValue := reflect.ValueOf(&next).Elem()
+	resolvedValue := reflect.ValueOf(resolved)
+	fileValue := reflect.ValueOf(file)
+	// ignored changes stay pending, and are logged again on each reload
+	applied := reloadState.file
+	appliedValue := reflect.ValueOf(&applied).Elem()
+	lastValue := reflect.ValueOf(reloadState.file)
+	for i := 0; i < fileValue.NumField(); i++ {
+		name := fileValue.Type().Field(i).Name
+		if runtimeFields[name] {
+			nextValue.Field(i).Set(resolvedValue.Field(i))
+			appliedValue.Field(i).Set(fileValue.Field(i))
+			continue
+		}
+		last, now := lastValue.Field(i).Interface(), fileValue.Field(i).Interface()
+		if !reflect.DeepEqual(last, now) {
+			log.WithFields(log.Fields{"field": name, "from": last, "to": now}).Warning(
+				"Ignoring change of configuration, restart the plugin to apply it ")
+		}
+	}
+
+	for name := range runtimeFields {
+		from := reflect.ValueOf(current).FieldByName(name).Interface()
+		to := nextValue.FieldByName(name).Interface()
+		if !reflect.DeepEqual(from, to) {
+			log.WithFields(log.Fields{"field": name, "from": from, "to": to}).Info("Changing configuration ")
+		}
+	}
+	reloadState.file = applied
+	return next, nil
+}
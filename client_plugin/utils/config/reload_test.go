@@ -0,0 +1,52 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadKeepsIgnoredChanges(t *testing.T) {
+	path := writeConfig(t, `{"LogLevel": "info", "Port": 1019}`)
+	defer os.Remove(path)
+	file, inFile, err := readFile(path)
+	assert.Nil(t, err)
+	l := testLayers(t, nil, nil)
+	current, _, errs := l.resolve(file, inFile)
+	assert.Nil(t, errs)
+
+	saved := reloadState
+	defer func() { reloadState = saved }()
+	reloadState.configFile = path
+	reloadState.file = file
+	reloadState.layers = l
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"LogLevel": "debug", "Port": 1020}`), 0644))
+	next, err := Reload(current)
+	assert.Nil(t, err)
+	assert.Equal(t, "debug", next.LogLevel)
+	assert.Equal(t, 1019, next.Port, "Port needs a restart")
+
+	// the port change is still pending after another reload
+	next, err = Reload(next)
+	assert.Nil(t, err)
+	assert.Equal(t, 1019, next.Port)
+	assert.Equal(t, 1019, reloadState.file.Port)
+	assert.Equal(t, "debug", reloadState.file.LogLevel)
+}
@@ -0,0 +1,230 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds device locators, which find the disk attached at a unit
+// of a virtual controller. Locators walk the controller's sysfs device
+// directory, so they don't depend on udev links, and match kernel uevents
+// reported for the disk, see uevent_linux.go.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// devLocator finds the disk attached at a unit of a controller
+type devLocator interface {
+	// find returns the device node of the disk, or "" if it isn't present
+	find() string
+
+	// match checks if a kernel uevent reports arrival of the disk
+	match(env map[string]string) bool
+}
+
+// devLocatorFactory creates a locator for the disk at unit of the
+// controller with sysfs device directory ctrlDir
+type devLocatorFactory func(ctrlDir string, unit int) (devLocator, error)
+
+// devLocators - locators by controller type
+var devLocators = map[string]devLocatorFactory{
+	ControllerPVSCSI:   newSCSILocator,
+	ControllerLSI:      newSCSILocator,
+	ControllerLSISAS:   newSCSILocator,
+	ControllerBusLogic: newSCSILocator,
+	ControllerSATA:     newSATALocator,
+	ControllerNVMe:     newNVMeLocator,
+}
+
+// newDevLocator returns a locator for the controller in volDev
+func newDevLocator(volDev *VolumeDevSpec) (devLocator, error) {
+	ctrlType := volDev.ControllerType
+	if ctrlType == "" {
+		// older servers attach to PVSCSI only and don't report the type
+		ctrlType = ControllerPVSCSI
+	}
+	factory, exists := devLocators[ctrlType]
+	if !exists {
+		return nil, fmt.Errorf("Unsupported controller type %s", ctrlType)
+	}
+
+	unit, err := strconv.Atoi(volDev.Unit)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid unit %s", volDev.Unit)
+	}
+	pciAddr, err := getControllerPciAddr(volDev)
+	if err != nil {
+		return nil, err
+	}
+	return factory(filepath.Join(sysPciDevs, pciAddr+".0"), unit)
+}
+
+// findBlockDevice returns the device node for the first block device
+// matching pattern, or ""
+func findBlockDevice(pattern string) string {
+	matches, _ := filepath.Glob(pattern)
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	return filepath.Join("/dev", filepath.Base(matches[0]))
+}
+
+// scsiLocator - disks on PVSCSI, LSI Logic (parallel and SAS) and BusLogic
+// controllers, e.g. for unit 1:
+// <ctrlDir>/host2/target2:0:1/2:0:1:0/block/sdb
+// <ctrlDir>/host2/port-2:1/end_device-2:1/target2:0:1/2:0:1:0/block/sdb
+type scsiLocator struct {
+	ctrlDir  string
+	ctrlName string
+	unit     int
+}
+
+func newSCSILocator(ctrlDir string, unit int) (devLocator, error) {
+	return &scsiLocator{ctrlDir: ctrlDir, ctrlName: filepath.Base(ctrlDir), unit: unit}, nil
+}
+
+func (l *scsiLocator) find() string {
+	for _, pattern := range []string{
+		"host*/target*/*:0:%d:0/block/*",
+		"host*/port-*/end_device-*/target*/*:0:%d:0/block/*",
+	} {
+		if device := findBlockDevice(filepath.Join(l.ctrlDir, fmt.Sprintf(pattern, l.unit))); device != "" {
+			return device
+		}
+	}
+	return ""
+}
+
+func (l *scsiLocator) match(env map[string]string) bool {
+	devPath := env["DEVPATH"]
+	if !strings.Contains(devPath, "/"+l.ctrlName+"/") {
+		return false
+	}
+	parts := strings.Split(devPath, "/")
+	if len(parts) < 3 || parts[len(parts)-2] != "block" {
+		return false
+	}
+	// host:channel:target:lun
+	addr := strings.Split(parts[len(parts)-3], ":")
+	return len(addr) == 4 && addr[1] == "0" && addr[2] == strconv.Itoa(l.unit) && addr[3] == "0"
+}
+
+// sataLocator - disks on AHCI controllers, unit is the port of the
+// controller, e.g. for unit 0: <ctrlDir>/ata3/host2/target2:0:0/2:0:0:0/block/sdb
+type sataLocator struct {
+	portDir  string
+	pathPart string
+}
+
+func newSATALocator(ctrlDir string, unit int) (devLocator, error) {
+	ports, _ := filepath.Glob(filepath.Join(ctrlDir, "ata*"))
+	// ataN are numbered across controllers, sort them numerically
+	sort.Sort(byAtaNumber(ports))
+	for i, port := range ports {
+		name := filepath.Base(port)
+		// port_no is 1 based, fall back to the order of ports if missing
+		portNo := i + 1
+		data, err := ioutil.ReadFile(filepath.Join(port, "ata_port", name, "port_no"))
+		if err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				portNo = n
+			}
+		}
+		if portNo == unit+1 {
+			return &sataLocator{
+				portDir:  port,
+				pathPart: "/" + filepath.Base(ctrlDir) + "/" + name + "/",
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("No SATA port for unit %d on %s", unit, ctrlDir)
+}
+
+func (l *sataLocator) find() string {
+	return findBlockDevice(filepath.Join(l.portDir, "host*/target*/*:0:0:0/block/*"))
+}
+
+func (l *sataLocator) match(env map[string]string) bool {
+	devPath := env["DEVPATH"]
+	parts := strings.Split(devPath, "/")
+	return strings.Contains(devPath, l.pathPart) && len(parts) > 2 && parts[len(parts)-2] == "block"
+}
+
+// ataNumber returns N of a port directory ataN
+func ataNumber(port string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(port), "ata"))
+	return n
+}
+
+// byAtaNumber sorts port directories by N of ataN
+type byAtaNumber []string
+
+func (p byAtaNumber) Len() int           { return len(p) }
+func (p byAtaNumber) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byAtaNumber) Less(i, j int) bool { return ataNumber(p[i]) < ataNumber(p[j]) }
+
+// nvmeLocator - namespaces on NVMe controllers, unit N is namespace N+1,
+// e.g. for unit 0: <ctrlDir>/nvme/nvme0/nvme0n1
+// Namespaces of native NVMe multipath live under /devices/virtual and
+// are not supported.
+type nvmeLocator struct {
+	ctrlDir  string
+	ctrlName string
+	nsid     int
+}
+
+func newNVMeLocator(ctrlDir string, unit int) (devLocator, error) {
+	return &nvmeLocator{ctrlDir: ctrlDir, ctrlName: filepath.Base(ctrlDir), nsid: unit + 1}, nil
+}
+
+func (l *nvmeLocator) find() string {
+	namespaces, _ := filepath.Glob(filepath.Join(l.ctrlDir, "nvme/nvme*/nvme*n*"))
+	for _, ns := range namespaces {
+		if nvmeNamespaceID(filepath.Base(ns)) == l.nsid {
+			return filepath.Join("/dev", filepath.Base(ns))
+		}
+	}
+	return ""
+}
+
+func (l *nvmeLocator) match(env map[string]string) bool {
+	devPath := env["DEVPATH"]
+	return strings.Contains(devPath, "/"+l.ctrlName+"/nvme/") &&
+		nvmeNamespaceID(filepath.Base(devPath)) == l.nsid
+}
+
+// nvmeNamespaceID returns the namespace ID of a namespace block device
+// nvme<ctrl>n<nsid>, or 0 for anything else, e.g. partitions nvme0n1p1
+func nvmeNamespaceID(name string) int {
+	if !strings.HasPrefix(name, "nvme") {
+		return 0
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "nvme"), "n")
+	if len(parts) != 2 {
+		return 0
+	}
+	if _, err := strconv.Atoi(parts[0]); err != nil {
+		return 0
+	}
+	nsid, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0
+	}
+	return nsid
+}
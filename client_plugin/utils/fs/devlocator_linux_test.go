@@ -0,0 +1,109 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+// Test device locators against fake sysfs controller directories
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makeSysfs creates dirs under a temp controller directory 0000:03:00.0
+func makeSysfs(t *testing.T, dirs ...string) string {
+	root, err := ioutil.TempDir("", "sysfs")
+	assert.Nil(t, err)
+	ctrlDir := filepath.Join(root, "0000:03:00.0")
+	for _, dir := range dirs {
+		assert.Nil(t, os.MkdirAll(filepath.Join(ctrlDir, dir), 0755))
+	}
+	return ctrlDir
+}
+
+func uevent(devPath string) map[string]string {
+	return map[string]string{"DEVPATH": "/devices/pci0000:00/0000:00:15.0" + devPath}
+}
+
+func TestSCSILocator(t *testing.T) {
+	ctrlDir := makeSysfs(t,
+		"host2/target2:0:0/2:0:0:0/block/sdb",
+		"host2/target2:0:1/2:0:1:0/block/sdc")
+	defer os.RemoveAll(filepath.Dir(ctrlDir))
+
+	l, err := newSCSILocator(ctrlDir, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "/dev/sdc", l.find())
+	assert.True(t, l.match(uevent("/0000:03:00.0/host2/target2:0:1/2:0:1:0/block/sdc")))
+	assert.False(t, l.match(uevent("/0000:03:00.0/host2/target2:0:0/2:0:0:0/block/sdb")), "Wrong unit")
+	assert.False(t, l.match(uevent("/0000:0b:00.0/host3/target3:0:1/3:0:1:0/block/sdd")), "Wrong controller")
+	assert.False(t, l.match(uevent("/0000:03:00.0/host2/target2:0:1/2:0:1:0/block/sdc/sdc1")), "Partition")
+
+	l, _ = newSCSILocator(ctrlDir, 2)
+	assert.Equal(t, "", l.find())
+}
+
+func TestSCSILocatorSAS(t *testing.T) {
+	ctrlDir := makeSysfs(t, "host2/port-2:1/end_device-2:1/target2:0:1/2:0:1:0/block/sdb")
+	defer os.RemoveAll(filepath.Dir(ctrlDir))
+
+	l, _ := newSCSILocator(ctrlDir, 1)
+	assert.Equal(t, "/dev/sdb", l.find())
+	assert.True(t, l.match(uevent("/0000:03:00.0/host2/port-2:1/end_device-2:1/target2:0:1/2:0:1:0/block/sdb")))
+}
+
+func TestSATALocator(t *testing.T) {
+	ctrlDir := makeSysfs(t,
+		"ata10/ata_port/ata10", "ata9/ata_port/ata9",
+		"ata10/host3/target3:0:0/3:0:0:0/block/sdc")
+	defer os.RemoveAll(filepath.Dir(ctrlDir))
+
+	// ports ordered numerically without port_no
+	l, err := newSATALocator(ctrlDir, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "/dev/sdc", l.find())
+	assert.True(t, l.match(uevent("/0000:03:00.0/ata10/host3/target3:0:0/3:0:0:0/block/sdc")))
+	assert.False(t, l.match(uevent("/0000:03:00.0/ata9/host2/target2:0:0/2:0:0:0/block/sdb")))
+
+	// port_no takes precedence
+	ioutil.WriteFile(filepath.Join(ctrlDir, "ata9/ata_port/ata9/port_no"), []byte("2\n"), 0644)
+	ioutil.WriteFile(filepath.Join(ctrlDir, "ata10/ata_port/ata10/port_no"), []byte("1\n"), 0644)
+	l, _ = newSATALocator(ctrlDir, 0)
+	assert.Equal(t, "/dev/sdc", l.find())
+
+	_, err = newSATALocator(ctrlDir, 5)
+	assert.NotNil(t, err, "No port for unit 5")
+}
+
+func TestNVMeLocator(t *testing.T) {
+	ctrlDir := makeSysfs(t, "nvme/nvme0/nvme0n1", "nvme/nvme0/nvme0n2", "nvme/nvme0/nvme0n1/nvme0n1p1")
+	defer os.RemoveAll(filepath.Dir(ctrlDir))
+
+	l, _ := newNVMeLocator(ctrlDir, 1)
+	assert.Equal(t, "/dev/nvme0n2", l.find())
+	assert.True(t, l.match(uevent("/0000:03:00.0/nvme/nvme0/nvme0n2")))
+	assert.False(t, l.match(uevent("/0000:03:00.0/nvme/nvme0/nvme0n1")))
+	assert.False(t, l.match(uevent("/0000:03:00.0/nvme/nvme0/nvme0n2/nvme0n2p1")), "Partition")
+}
+
+func TestNewDevLocatorErrors(t *testing.T) {
+	_, err := newDevLocator(&VolumeDevSpec{Unit: "0", ControllerType: "floppy"})
+	assert.NotNil(t, err, "Unknown controller type")
+	_, err = newDevLocator(&VolumeDevSpec{Unit: "x", ControllerType: ControllerNVMe})
+	assert.NotNil(t, err, "Invalid unit")
+}
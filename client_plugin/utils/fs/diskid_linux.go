@@ -0,0 +1,126 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds lookup and verification of disks by the VMDK UUID. With
+// disk.EnableUUID set for the VM the guest sees the UUID as the disk serial
+// and WWN, e.g. UUID 6000C29a-bf8e-... is reported as naa.6000c29abf8e...
+// in sysfs wwid and linked as /dev/disk/by-id/wwn-0x6000c29abf8e...
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// hex digits of a disk UUID, and of an NVMe EUI-64
+	diskUUIDLen = 32
+	eui64Len    = 16
+)
+
+// VerifyDevice checks that device is the disk attached for volDev, so a
+// filesystem is never created on or mounted from a wrong disk. Disks are
+// verified when the server reports the disk UUID.
+func VerifyDevice(device string, volDev *VolumeDevSpec) error {
+	if volDev.DiskUUID == "" {
+		return nil
+	}
+
+	node, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return fmt.Errorf("Can't verify identity of device %s: %v", device, err)
+	}
+	wwid := diskWWID(filepath.Join(bdevPath, filepath.Base(node)))
+	if wwid == "" {
+		// older kernels don't report wwid, the udev link is good enough
+		if findDiskByLink(volDev.DiskUUID) == node {
+			return nil
+		}
+		return fmt.Errorf("Can't verify identity of device %s, expected disk %s",
+			device, volDev.DiskUUID)
+	}
+	if !matchDiskID(wwid, volDev.DiskUUID) {
+		return fmt.Errorf("Device %s is disk %s, expected disk %s",
+			device, wwid, volDev.DiskUUID)
+	}
+	return nil
+}
+
+// findDiskByUUID returns the device node of the disk with uuid, or ""
+func findDiskByUUID(uuid string) string {
+	if device := findDiskByLink(uuid); device != "" {
+		return device
+	}
+	disks, _ := filepath.Glob(filepath.Join(bdevPath, "*"))
+	for _, disk := range disks {
+		if matchDiskID(diskWWID(disk), uuid) {
+			return filepath.Join("/dev", filepath.Base(disk))
+		}
+	}
+	return ""
+}
+
+// findDiskByLink returns the device node udev linked for the disk with uuid, or ""
+func findDiskByLink(uuid string) string {
+	device, err := filepath.EvalSymlinks(diskPathByDevID + normalizeDiskID(uuid))
+	if err != nil {
+		return ""
+	}
+	return device
+}
+
+// diskWWID returns the world wide ID of the block device with sysfs
+// directory sysDir, SCSI disks report it for the device, NVMe
+// namespaces for the block device itself
+func diskWWID(sysDir string) string {
+	for _, file := range []string{"device/wwid", "wwid"} {
+		data, err := ioutil.ReadFile(filepath.Join(sysDir, file))
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
+
+// normalizeDiskID returns lower case hex digits of a disk UUID or WWID
+func normalizeDiskID(id string) string {
+	id = strings.ToLower(id)
+	for _, prefix := range []string{"naa.", "eui.", "t10.", "uuid.", "0x"} {
+		id = strings.TrimPrefix(id, prefix)
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') {
+			return r
+		}
+		return -1
+	}, id)
+}
+
+// matchDiskID checks if wwid identifies the disk with uuid. SCSI disks
+// report the UUID as NAA ID, NVMe namespaces as NGUID, or as EUI-64 of
+// the first 8 bytes of the UUID if they have no NGUID.
+func matchDiskID(wwid string, uuid string) bool {
+	w := normalizeDiskID(wwid)
+	u := normalizeDiskID(uuid)
+	if len(u) != diskUUIDLen || w == "" {
+		return false
+	}
+	if strings.HasPrefix(strings.ToLower(wwid), "eui.") && len(w) == eui64Len {
+		return w == u[:eui64Len]
+	}
+	return w == u
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const diskUUID = "6000C29a-bf8e-3b2d-24e5-0b21fbd6a5c1"
+
+func TestMatchDiskID(t *testing.T) {
+	assert.True(t, matchDiskID("naa.6000c29abf8e3b2d24e50b21fbd6a5c1", diskUUID))
+	assert.True(t, matchDiskID("0x6000C29ABF8E3B2D24E50B21FBD6A5C1", diskUUID))
+	assert.False(t, matchDiskID("naa.6000c29abf8e3b2d24e50b21fbd6a5c2", diskUUID))
+	// IDs must match exactly, not just contain the UUID
+	assert.False(t, matchDiskID("naa.6000c29abf8e3b2d24e50b21fbd6a5c100", diskUUID))
+	assert.False(t, matchDiskID("naa.6000c29abf8e3b2d", diskUUID))
+
+	// NVMe namespaces with NGUID, or with EUI-64 only
+	assert.True(t, matchDiskID("eui.6000c29abf8e3b2d24e50b21fbd6a5c1", diskUUID))
+	assert.True(t, matchDiskID("eui.6000c29abf8e3b2d", diskUUID))
+	assert.False(t, matchDiskID("eui.6000c29abf8e3b2e", diskUUID))
+	assert.False(t, matchDiskID("eui.24e50b21fbd6a5c1", diskUUID))
+	assert.False(t, matchDiskID("eui.6000c29abf8e3b2d", "6000C29a-bf8e-3b2d"))
+	assert.False(t, matchDiskID("", diskUUID))
+	assert.False(t, matchDiskID("naa.6000c29abf8e3b2d24e50b21fbd6a5c1", ""))
+}
+
+func TestDiskWWID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysblock")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// SCSI disks report wwid for the device, NVMe for the namespace
+	os.MkdirAll(filepath.Join(dir, "sdb/device"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sdb/device/wwid"), []byte("naa.6000c29abf8e3b2d24e50b21fbd6a5c1\n"), 0644)
+	os.MkdirAll(filepath.Join(dir, "nvme0n1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "nvme0n1/wwid"), []byte("eui.6000c29abf8e3b2d\n"), 0644)
+
+	assert.Equal(t, "naa.6000c29abf8e3b2d24e50b21fbd6a5c1", diskWWID(filepath.Join(dir, "sdb")))
+	assert.Equal(t, "eui.6000c29abf8e3b2d", diskWWID(filepath.Join(dir, "nvme0n1")))
+	assert.Equal(t, "", diskWWID(filepath.Join(dir, "sdc")))
+}
+
+func TestVerifyDeviceWithoutUUID(t *testing.T) {
+	// older servers don't report the UUID, nothing to verify
+	assert.Nil(t, VerifyDevice("/dev/sdb", &VolumeDevSpec{Unit: "0"}))
+}
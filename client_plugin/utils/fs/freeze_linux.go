@@ -0,0 +1,61 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds filesystem freeze, see fsfreeze(8). A frozen filesystem
+// is consistent on disk and blocks writes until thawed, e.g. while the
+// disk is snapshotted.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const (
+	fiFreeze = 0xC0045877 // FIFREEZE, _IOWR('X', 119, int)
+	fiThaw   = 0xC0045878 // FITHAW, _IOWR('X', 120, int)
+)
+
+// Freeze flushes the filesystem mounted at mountPoint and blocks writes
+// to it until it is thawed
+func Freeze(mountPoint string) error {
+	if err := freezeIoctl(mountPoint, fiFreeze); err != nil {
+		return fmt.Errorf("Failed to freeze %s: %v", mountPoint, err)
+	}
+	return nil
+}
+
+// Thaw resumes writes to the frozen filesystem mounted at mountPoint
+func Thaw(mountPoint string) error {
+	if err := freezeIoctl(mountPoint, fiThaw); err != nil {
+		return fmt.Errorf("Failed to thaw %s: %v", mountPoint, err)
+	}
+	return nil
+}
+
+// freezeIoctl runs a freeze ioctl on the filesystem mounted at mountPoint
+func freezeIoctl(mountPoint string, request uintptr) error {
+	f, err := os.Open(mountPoint)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), request, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -14,8 +14,29 @@
 
 package fs
 
+// Controller types reported in VolumeDevSpec
+const (
+	ControllerPVSCSI   = "pvscsi"
+	ControllerLSI      = "lsilogic"
+	ControllerLSISAS   = "lsilogic-sas"
+	ControllerBusLogic = "buslogic"
+	ControllerSATA     = "sata"
+	ControllerNVMe     = "nvme"
+)
+
 // VolumeDevSpec - volume spec returned from the server on an attach
 type VolumeDevSpec struct {
 	Unit                    string
 	ControllerPciSlotNumber string
+	ControllerType          string // one of Controller*, PVSCSI if not reported
+	Bus                     string // bus number of the controller in the VM
+	DiskUUID                string
+}
+
+// Usage - space and inodes used in a mounted filesystem
+type Usage struct {
+	UsedBytes  uint64
+	FreeBytes  uint64 // available to unprivileged users
+	InodesUsed uint64
+	InodesFree uint64
 }
@@ -20,7 +20,6 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"golang.org/x/exp/inotify"
 	"io"
 	"io/ioutil"
 	"os"
@@ -35,88 +34,21 @@ const (
 	// FstypeDefault contains the default FS to be used when not specified by the user.
 	FstypeDefault = "ext4"
 
-	sleepBeforeMount = 1 * time.Second          // time to sleep in case of watch failure
-	sysPciDevs       = "/sys/bus/pci/devices"   // All PCI devices on the host
-	sysPciSlots      = "/sys/bus/pci/slots"     // PCI slots on the host
-	pciAddrLen       = 10                       // Length of PCI dev addr
-	diskPathByDevID  = "/dev/disk/by-id/wwn-0x" // Path for devices named by ID
-	scsiHostPath     = "/sys/class/scsi_host/"  // Path for scsi hosts
-	devWaitTimeout   = 10 * time.Second         // give it plenty of time to sense the attached disk
-	bdevPath         = "/sys/block/"
-	deleteFile       = "/device/delete"
-	watchPath        = "/dev/disk/by-id"
-	diskWatchPath    = "/dev/disk/by-path"
+	sysPciDevs      = "/sys/bus/pci/devices"   // All PCI devices on the host
+	sysPciSlots     = "/sys/bus/pci/slots"     // PCI slots on the host
+	pciAddrLen      = 10                       // Length of PCI dev addr
+	diskPathByDevID = "/dev/disk/by-id/wwn-0x" // Path for devices named by ID
+	scsiHostPath    = "/sys/class/scsi_host/"  // Path for scsi hosts
+	devWaitTimeout  = 10 * time.Second         // give it plenty of time to sense the attached disk
+	bdevPath        = "/sys/block/"
+	deleteFile      = "/device/delete"
+	sysDevBlock     = "/sys/dev/block"
+	blkFlsBuf       = 0x1261 // BLKFLSBUF ioctl, flush buffer cache
 )
 
 // BinSearchPath contains search paths for host binaries
 var BinSearchPath = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin"}
 
-// DevAttachWaitPrep creates a watcher that watches disk events.
-func DevAttachWaitPrep() (*inotify.Watcher, error) {
-	return devAttachWaitPrep(diskWatchPath)
-}
-
-// devAttachWaitPrep creates a watcher that watches devPath.
-func devAttachWaitPrep(devPath string) (*inotify.Watcher, error) {
-	watcher, errWatcher := inotify.NewWatcher()
-	if errWatcher != nil {
-		log.WithFields(log.Fields{"err": errWatcher.Error()}).Error("Failed to create watcher ")
-		return nil, errors.New("Failed to create watcher")
-	}
-
-	err := watcher.Watch(devPath)
-	if err != nil {
-		log.WithFields(log.Fields{"path": devPath, "err": err.Error()}).Error("Failed to watch ")
-		return nil, fmt.Errorf("Failed to watch path %s", devPath)
-	}
-	return watcher, nil
-}
-
-// DevAttachWait waits for attach operation to be completed
-func DevAttachWait(watcher *inotify.Watcher, volDev *VolumeDevSpec) error {
-	device, err := getDevicePath(volDev)
-	if err != nil {
-		log.WithFields(log.Fields{"volDev": *volDev, "err": err}).Error("Failed to get device path ")
-		return err
-	}
-	devAttachWait(watcher, device)
-	return nil
-}
-
-// devAttachWait waits for attach operation to be completed
-func devAttachWait(watcher *inotify.Watcher, device string) {
-loop:
-	for {
-		select {
-		case ev := <-watcher.Event:
-			log.Debug("event: ", ev)
-			if ev.Name == device {
-				// Log when the device is discovered
-				log.WithFields(
-					log.Fields{"device": device, "event": ev},
-				).Info("Scan complete ")
-				break loop
-			}
-		case err := <-watcher.Error:
-			log.WithFields(
-				log.Fields{"device": device, "error": err},
-			).Error("Hit error during watch ")
-			break loop
-		case <-time.After(devWaitTimeout):
-			log.WithFields(
-				log.Fields{"timeout": devWaitTimeout, "device": device},
-			).Warning("Exceeded timeout while waiting for device attach to complete")
-			break loop
-		}
-	}
-	watcher.Close()
-}
-
-// DevAttachWaitFallback performs basic fallback in case of watch failure.
-func DevAttachWaitFallback() {
-	time.Sleep(sleepBeforeMount)
-}
-
 // Mkdir creates a directory at the specified path
 func Mkdir(path string) error {
 	stat, err := os.Lstat(path)
@@ -136,7 +68,7 @@ func Mkdir(path string) error {
 
 // Mkfs creates a filesystem at the specified volDev.
 func Mkfs(fstype string, label string, volDev *VolumeDevSpec) error {
-	device, err := getDevicePath(volDev)
+	device, err := GetDevicePath(volDev)
 	if err != nil {
 		log.WithFields(log.Fields{"volDev": *volDev, "err": err}).Error("Failed to get device path ")
 		return err
@@ -146,20 +78,26 @@ func Mkfs(fstype string, label string, volDev *VolumeDevSpec) error {
 
 // MkfsByDevicePath creates a filesystem at the specified device.
 func MkfsByDevicePath(fstype string, label string, device string) error {
-	var err error
-	var out []byte
+	return MkfsWithOptions(fstype, label, device, nil)
+}
 
+// MkfsWithOptions creates a filesystem at the specified device, passing
+// options to the mkfs command of fstype.
+func MkfsWithOptions(fstype string, label string, device string, options []string) error {
 	// Identify mkfscmd for fstype
 	mkfscmd := mkfsLookup()[fstype]
 
 	// Workaround older versions of e2fsprogs, issue 629.
 	// If mkfscmd is of an ext* filesystem use -F flag
 	// to avoid having mkfs command to expect user confirmation.
+	var args []string
 	if strings.Split(mkfscmd, ".")[1][0:3] == "ext" {
-		out, err = exec.Command(mkfscmd, "-F", "-L", label, device).CombinedOutput()
-	} else {
-		out, err = exec.Command(mkfscmd, "-L", label, device).CombinedOutput()
+		args = append(args, "-F")
 	}
+	args = append(args, "-L", label)
+	args = append(args, options...)
+	args = append(args, device)
+	out, err := exec.Command(mkfscmd, args...).CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("Failed to create filesystem on %s: %s. Output = %s",
 			device, err, out)
@@ -201,27 +139,34 @@ func mkfsLookup() map[string]string {
 
 // Mount the filesystem (`fs`) on the volDev at the given mountpoint.
 func Mount(mountpoint string, fstype string, volDev *VolumeDevSpec, isReadOnly bool) error {
-	device, err := getDevicePath(volDev)
+	device, err := GetDevicePath(volDev)
 	if err != nil {
 		log.WithFields(log.Fields{"volDev": *volDev, "err": err}).Error("Failed to get device path ")
 		return err
 	}
-	return MountByDevicePath(mountpoint, fstype, device, isReadOnly)
+	return MountByDevicePath(log.NewEntry(log.StandardLogger()), mountpoint, fstype, device, isReadOnly)
 }
 
 // MountByDevicePath mounts the filesystem (`fs`) on the device at the given mount point.
-func MountByDevicePath(mountpoint string, fstype string, device string, isReadOnly bool) error {
-	log.WithFields(log.Fields{
+func MountByDevicePath(logger *log.Entry, mountpoint string, fstype string, device string, isReadOnly bool) error {
+	return MountByDevicePathWithOptions(logger, mountpoint, fstype, device, isReadOnly, "")
+}
+
+// MountByDevicePathWithOptions mounts the filesystem (`fs`) on the device at the
+// given mount point, passing filesystem specific options, e.g. ProjectQuotaOption.
+func MountByDevicePathWithOptions(logger *log.Entry, mountpoint string, fstype string, device string, isReadOnly bool, options string) error {
+	logger.WithFields(log.Fields{
 		"device":     device,
 		"fstype":     fstype,
 		"mountpoint": mountpoint,
+		"options":    options,
 	}).Debug("Calling syscall.Mount() ")
 
 	flags := 0
 	if isReadOnly {
 		flags = syscall.MS_RDONLY
 	}
-	err := syscall.Mount(device, mountpoint, fstype, uintptr(flags), "")
+	err := syscall.Mount(device, mountpoint, fstype, uintptr(flags), options)
 	if err != nil {
 		return fmt.Errorf("Failed to mount device %s at %s: %s", device, mountpoint, err)
 	}
@@ -256,6 +201,21 @@ func MountWithID(mountpoint string, fstype string, id string, isReadOnly bool) e
 	return nil
 }
 
+// GetUsage returns space and inodes used in the filesystem at mountPoint
+func GetUsage(mountPoint string) (*Usage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mountPoint, &st); err != nil {
+		return nil, fmt.Errorf("Failed to get usage of %s: %v", mountPoint, err)
+	}
+	bsize := uint64(st.Bsize)
+	return &Usage{
+		UsedBytes:  (st.Blocks - st.Bfree) * bsize,
+		FreeBytes:  st.Bavail * bsize,
+		InodesUsed: st.Files - st.Ffree,
+		InodesFree: st.Ffree,
+	}, nil
+}
+
 // Unmount a device from the given mount point.
 func Unmount(mountPoint string) error {
 	err := syscall.Unmount(mountPoint, 0)
@@ -276,7 +236,15 @@ func GetDevicePathByID(id string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	time.Sleep(10)
+
+	// start listening before the rescan reports the device
+	logger := log.NewEntry(log.StandardLogger())
+	watcher, err := DevAttachWaitPrep(logger)
+	if err != nil {
+		return "", err
+	}
+	defer watcher.Close()
+
 	for _, host := range hosts {
 		//Scan so we may have the device before attempting a mount
 		scanHost := scsiHostPath + host.Name() + "/scan"
@@ -288,22 +256,15 @@ func GetDevicePathByID(id string) (string, error) {
 		}
 	}
 
-	watcher, errWatch := devAttachWaitPrep(watchPath)
-
+	// the by-id link is created by udev after the kernel event,
+	// so check for it on every event
 	device := makeDevicePathWithID(id)
-
-	if errWatch != nil {
-		time.Sleep(10)
-	} else {
-		// Wait for the attach to complete, may timeout
-		// in which case we continue creating the file system.
-		devAttachWait(watcher, device)
-	}
-	_, err = os.Stat(device)
-	if err != nil {
-		return "", err
-	}
-	return device, nil
+	return watcher.wait(logger, func(env map[string]string) string {
+		if _, err := os.Stat(device); err != nil {
+			return ""
+		}
+		return device
+	})
 }
 
 // DeleteDevicePathWithID - delete device with given ID
@@ -327,8 +288,75 @@ func DeleteDevicePathWithID(id string) error {
 	return nil
 }
 
-// getDevicePath returns the device path or error.
-func getDevicePath(volDev *VolumeDevSpec) (string, error) {
+// GetDevicePathByNumber returns the device node of the block device major:minor
+func GetDevicePathByNumber(major uint32, minor uint32) (string, error) {
+	link, err := os.Readlink(fmt.Sprintf("%s/%d:%d", sysDevBlock, major, minor))
+	if err != nil {
+		return "", fmt.Errorf("Failed to find block device %d:%d: %s", major, minor, err)
+	}
+	return filepath.Join("/dev", filepath.Base(link)), nil
+}
+
+// DeleteDevice flushes buffers of an unused disk and removes it from the
+// guest, so no stale device is left behind once the disk is detached.
+// Disks without a SCSI device, e.g. NVMe namespaces, are left alone.
+func DeleteDevice(logger *log.Entry, device string) error {
+	node, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return err
+	}
+	sysDevice := bdevPath + filepath.Base(node) + "/device"
+	if _, err = os.Stat(bdevPath + filepath.Base(node) + deleteFile); err != nil {
+		logger.WithFields(log.Fields{"device": node}).Debug("Device can't be deleted, skipping ")
+		return nil
+	}
+
+	if err = flushDevice(node); err != nil {
+		return err
+	}
+	// stop I/O to the disk before it is gone
+	if err = ioutil.WriteFile(sysDevice+"/state", []byte("offline"), 0644); err != nil {
+		logger.WithFields(log.Fields{"device": node, "err": err}).Warning("Failed to offline device ")
+	}
+
+	logger.WithFields(log.Fields{"device": node}).Debug("Deleting device ")
+	return ioutil.WriteFile(sysDevice+"/delete", []byte("1"), 0644)
+}
+
+// flushDevice writes dirty buffers of the block device to the disk and
+// drops them
+func flushDevice(device string) error {
+	f, err := os.Open(device)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("Failed to flush device %s: %s", device, err)
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkFlsBuf, 0)
+	if errno != 0 {
+		return fmt.Errorf("Failed to flush buffers of device %s: %s", device, errno)
+	}
+	return nil
+}
+
+// GetDevicePath returns the device node of the disk attached at volDev or error.
+func GetDevicePath(volDev *VolumeDevSpec) (string, error) {
+	match, err := newDevMatcher(volDev)
+	if err != nil {
+		return "", err
+	}
+	device := match(nil)
+	if device == "" {
+		return "", fmt.Errorf("Device not found")
+	}
+	return device, VerifyDevice(device, volDev)
+}
+
+// getControllerPciAddr returns the PCI address of the controller in volDev
+func getControllerPciAddr(volDev *VolumeDevSpec) (string, error) {
 	// Get the device node for the unit returned from the attach.
 	// Lookup each device that has a label and if that label matches
 	// the one for the given bus number.
@@ -338,7 +366,7 @@ func getDevicePath(volDev *VolumeDevSpec) (string, error) {
 
 	fh, err := os.Open(pciSlotAddr)
 	if err != nil {
-		log.WithFields(log.Fields{"Error": err}).Warn("Get device path failed for unit# %s @ PCI slot %s: ",
+		log.WithFields(log.Fields{"Error": err}).Warnf("Get device path failed for unit# %s @ PCI slot %s: ",
 			volDev.Unit, volDev.ControllerPciSlotNumber)
 		return "", fmt.Errorf("Device not found")
 	}
@@ -348,9 +376,9 @@ func getDevicePath(volDev *VolumeDevSpec) (string, error) {
 
 	fh.Close()
 	if err != nil && err != io.EOF {
-		log.WithFields(log.Fields{"Error": err}).Warn("Get device path failed for unit# %s @ PCI slot %s: ",
+		log.WithFields(log.Fields{"Error": err}).Warnf("Get device path failed for unit# %s @ PCI slot %s: ",
 			volDev.Unit, volDev.ControllerPciSlotNumber)
 		return "", fmt.Errorf("Device not found")
 	}
-	return fmt.Sprintf("/dev/disk/by-path/pci-%s.0-scsi-0:0:%s:0", string(buf), volDev.Unit), nil
+	return string(buf), nil
 }
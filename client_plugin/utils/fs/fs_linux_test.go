@@ -0,0 +1,39 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+// Test filesystem usage reporting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	usage, err := GetUsage(dir)
+	assert.Nil(t, err)
+	assert.True(t, usage.UsedBytes > 0 || usage.FreeBytes > 0, "Empty filesystem")
+
+	_, err = GetUsage(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+}
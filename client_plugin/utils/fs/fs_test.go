@@ -16,18 +16,17 @@ package fs
 
 import (
 	"github.com/stretchr/testify/assert"
-	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
 	"testing"
 )
 
 const funnyfs = "funnyfs"
 
 func TestVerifyFSSupport(t *testing.T) {
-	err := fs.VerifyFSSupport(fs.FstypeDefault)
-	assert.Nil(t, err, "Fstype %s should be supported", fs.FstypeDefault)
+	err := VerifyFSSupport(FstypeDefault)
+	assert.Nil(t, err, "Fstype %s should be supported", FstypeDefault)
 }
 
 func TestVerifyFSSupportError(t *testing.T) {
-	err := fs.VerifyFSSupport(funnyfs)
+	err := VerifyFSSupport(funnyfs)
 	assert.NotNil(t, err, "Fstype %s shouldn't be supported", funnyfs)
 }
@@ -0,0 +1,126 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds online growth of filesystems to the size of their
+// device, e.g. after the disk of a volume was resized on ESX.
+
+package fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	blkGetSize64 = 0x80081272 // BLKGETSIZE64 ioctl, device size in bytes
+
+	// superblocks, see ext4(5) and xfs(5)
+	extSuperblockOffset = 1024
+	extMagic            = 0xEF53
+	extIncompat64Bit    = 0x80
+	xfsMagic            = 0x58465342 // "XFSB"
+	superblockLen       = 1024
+)
+
+// GrowFilesystem grows the filesystem on device, mounted read-write at
+// mountpoint, to the size of the device. Does nothing if the filesystem
+// already fills the device or can't be grown online.
+func GrowFilesystem(logger *log.Entry, fstype string, device string, mountpoint string) error {
+	var cmd *exec.Cmd
+	var offset int64
+	switch fstype {
+	case "ext3", "ext4":
+		cmd = exec.Command("resize2fs", device)
+		offset = extSuperblockOffset
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", mountpoint)
+	default:
+		return nil
+	}
+
+	deviceSize, fsSize, err := getSizes(device, offset)
+	if err != nil {
+		return fmt.Errorf("Failed to get filesystem size on %s: %v", device, err)
+	}
+	if fsSize >= deviceSize {
+		return nil
+	}
+	logger.WithFields(log.Fields{"device": device, "deviceSize": deviceSize,
+		"fsSize": fsSize}).Info("Growing filesystem to the size of the device ")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to grow filesystem on %s: %s. Output = %s", device, err,
+			strings.TrimSpace(string(out)))
+	}
+	logger.WithFields(log.Fields{"device": device, "output": strings.TrimSpace(string(out))}).Debug("Filesystem grown ")
+	return nil
+}
+
+// getSizes returns the size of device, and the size of the filesystem in
+// its superblock at offset
+func getSizes(device string, offset int64) (uint64, uint64, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var deviceSize uint64
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkGetSize64, uintptr(unsafe.Pointer(&deviceSize)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+
+	sb := make([]byte, superblockLen)
+	if _, err = f.ReadAt(sb, offset); err != nil {
+		return 0, 0, err
+	}
+	var fsSize uint64
+	if offset == extSuperblockOffset {
+		fsSize, err = extSize(sb)
+	} else {
+		fsSize, err = xfsSize(sb)
+	}
+	return deviceSize, fsSize, err
+}
+
+// extSize returns the filesystem size in an ext2/3/4 superblock
+func extSize(sb []byte) (uint64, error) {
+	if binary.LittleEndian.Uint16(sb[0x38:]) != extMagic {
+		return 0, fmt.Errorf("No ext superblock")
+	}
+	blocks := uint64(binary.LittleEndian.Uint32(sb[0x4:]))
+	if binary.LittleEndian.Uint32(sb[0x60:])&extIncompat64Bit != 0 {
+		blocks |= uint64(binary.LittleEndian.Uint32(sb[0x150:])) << 32
+	}
+	blockSize := uint64(1024) << binary.LittleEndian.Uint32(sb[0x18:])
+	return blocks * blockSize, nil
+}
+
+// xfsSize returns the size of the data section in an XFS superblock
+func xfsSize(sb []byte) (uint64, error) {
+	if binary.BigEndian.Uint32(sb) != xfsMagic {
+		return 0, fmt.Errorf("No XFS superblock")
+	}
+	blockSize := uint64(binary.BigEndian.Uint32(sb[4:]))
+	return binary.BigEndian.Uint64(sb[8:]) * blockSize, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtSize(t *testing.T) {
+	sb := make([]byte, superblockLen)
+	_, err := extSize(sb)
+	assert.NotNil(t, err, "No magic")
+
+	binary.LittleEndian.PutUint16(sb[0x38:], extMagic)
+	binary.LittleEndian.PutUint32(sb[0x4:], 262144)
+	binary.LittleEndian.PutUint32(sb[0x18:], 2) // 4k blocks
+	binary.LittleEndian.PutUint32(sb[0x150:], 1)
+	size, err := extSize(sb)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<30), size, "High blocks count ignored without 64bit")
+
+	binary.LittleEndian.PutUint32(sb[0x60:], extIncompat64Bit)
+	size, err = extSize(sb)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<30)+uint64(1<<32)*4096, size)
+}
+
+func TestXfsSize(t *testing.T) {
+	sb := make([]byte, superblockLen)
+	_, err := xfsSize(sb)
+	assert.NotNil(t, err, "No magic")
+
+	binary.BigEndian.PutUint32(sb, xfsMagic)
+	binary.BigEndian.PutUint32(sb[4:], 4096)
+	binary.BigEndian.PutUint64(sb[8:], 2621440)
+	size, err := xfsSize(sb)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10<<30), size)
+}
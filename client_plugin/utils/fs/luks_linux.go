@@ -0,0 +1,109 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds LUKS encryption of volume disks, see cryptsetup(8).
+// The filesystem is created on and mounted from the device mapping of the
+// opened LUKS container. Keys are passed on stdin, never on the command line.
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// LuksCipherDefault - cipher of LUKS containers if not specified
+	LuksCipherDefault = "aes-xts-plain64"
+
+	cryptsetupCmd = "cryptsetup"
+	devMapperPath = "/dev/mapper"
+	luksMapPrefix = "vdvs-"
+)
+
+// LuksMapName returns the device mapping name for the volume
+func LuksMapName(volName string) string {
+	return luksMapPrefix + strings.Replace(volName, "/", "_", -1)
+}
+
+// cryptsetup runs cryptsetup with key on stdin
+func cryptsetup(key []byte, args ...string) error {
+	cmd := exec.Command(cryptsetupCmd, args...)
+	if key != nil {
+		cmd.Stdin = bytes.NewReader(key)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %s. Output = %s", cryptsetupCmd, args[0], err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// LuksFormat creates a LUKS container with cipher on device
+func LuksFormat(logger *log.Entry, device string, cipher string, key []byte) error {
+	logger.WithFields(log.Fields{"device": device, "cipher": cipher}).Info("Formatting LUKS container ")
+	return cryptsetup(key, "luksFormat", "--batch-mode", "--cipher", cipher, "--key-file=-", device)
+}
+
+// IsLuks checks if device holds a LUKS container
+func IsLuks(device string) bool {
+	return exec.Command(cryptsetupCmd, "isLuks", device).Run() == nil
+}
+
+// LuksOpen opens the LUKS container on device as mapping mapName and
+// returns the mapped device. allowDiscards passes trims to the disk.
+func LuksOpen(device string, mapName string, key []byte, allowDiscards bool) (string, error) {
+	args := []string{"open", "--type", "luks", "--key-file=-"}
+	if allowDiscards {
+		args = append(args, "--allow-discards")
+	}
+	if err := cryptsetup(key, append(args, device, mapName)...); err != nil {
+		return "", err
+	}
+	return filepath.Join(devMapperPath, mapName), nil
+}
+
+// LuksClose closes the mapping mapName, if it is open
+func LuksClose(mapName string) error {
+	if !LuksIsOpen(mapName) {
+		return nil
+	}
+	return cryptsetup(nil, "close", mapName)
+}
+
+// LuksIsOpen checks if the mapping mapName is open
+func LuksIsOpen(mapName string) bool {
+	_, err := os.Stat(filepath.Join(devMapperPath, mapName))
+	return err == nil
+}
+
+// GetLuksDevice returns the disk device under the open mapping mapName
+func GetLuksDevice(mapName string) (string, error) {
+	node, err := filepath.EvalSymlinks(filepath.Join(devMapperPath, mapName))
+	if err != nil {
+		return "", err
+	}
+	slaves, _ := filepath.Glob(filepath.Join(bdevPath, filepath.Base(node), "slaves", "*"))
+	if len(slaves) != 1 {
+		return "", fmt.Errorf("Can't find the device under mapping %s", mapName)
+	}
+	return filepath.Join("/dev", filepath.Base(slaves[0])), nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds project quotas of XFS and ext4. A directory tree is
+// assigned a project ID, inherited by files created in it, and the space
+// used by the project is limited with quotactl(2), see xfs_quota(8).
+// The filesystem must be mounted with ProjectQuotaOption.
+
+package fs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// ProjectQuotaOption - mount option enabling project quota enforcement
+	ProjectQuotaOption = "prjquota"
+
+	fsIocGetXattr      = 0x801C581F // FS_IOC_FSGETXATTR, _IOR('X', 31, struct fsxattr)
+	fsIocSetXattr      = 0x401C5820 // FS_IOC_FSSETXATTR, _IOW('X', 32, struct fsxattr)
+	fsXflagProjInherit = 0x200      // FS_XFLAG_PROJINHERIT
+
+	qGetQuota  = 0x800007 // Q_GETQUOTA
+	qSetQuota  = 0x800008 // Q_SETQUOTA
+	prjQuota   = 2        // PRJQUOTA
+	qifBLimits = 1        // QIF_BLIMITS
+	qifBlkSize = 1024     // quota block size, QIF_DQBLKSIZE
+)
+
+// fsxattr - struct fsxattr from linux/fs.h
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// ifDqblk - struct if_dqblk from linux/quota.h
+type ifDqblk struct {
+	bHardLimit uint64
+	bSoftLimit uint64
+	curSpace   uint64
+	iHardLimit uint64
+	iSoftLimit uint64
+	curInodes  uint64
+	bTime      uint64
+	iTime      uint64
+	valid      uint32
+	pad        uint32
+}
+
+// EnableProjectQuota prepares the filesystem on device for project quotas.
+// ext4 needs the quota and project features, XFS only the mount option.
+func EnableProjectQuota(fstype string, device string) error {
+	switch fstype {
+	case "xfs":
+		return nil
+	case "ext4":
+		out, err := exec.Command("tune2fs", "-O", "quota,project", device).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to enable project quota on %s: %s. Output = %s",
+				device, err, out)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Project quota is not supported for %s filesystem", fstype)
+	}
+}
+
+// SetProjectID assigns project id to the directory dir, inherited by
+// files and directories created in it
+func SetProjectID(dir string, id uint32) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to set project ID of %s: %v", dir, err)
+	}
+	defer f.Close()
+
+	var attr fsxattr
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetXattr,
+		uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("Failed to get attributes of %s: %v", dir, errno)
+	}
+	attr.projid = id
+	attr.xflags |= fsXflagProjInherit
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocSetXattr,
+		uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("Failed to set project ID of %s: %v", dir, errno)
+	}
+	return nil
+}
+
+// SetProjectQuota limits space used by project id in the filesystem on
+// device to limit bytes, 0 removes the limit
+func SetProjectQuota(logger *log.Entry, device string, id uint32, limit uint64) error {
+	dq := ifDqblk{
+		bHardLimit: (limit + qifBlkSize - 1) / qifBlkSize,
+		valid:      qifBLimits,
+	}
+	if err := quotactl(qSetQuota, device, id, &dq); err != nil {
+		return fmt.Errorf("Failed to set quota of project %d on %s: %v", id, device, err)
+	}
+	logger.WithFields(log.Fields{"device": device, "project": id, "limit": limit}).Debug("Project quota set ")
+	return nil
+}
+
+// GetProjectUsage returns space used by project id in the filesystem on device
+func GetProjectUsage(device string, id uint32) (uint64, error) {
+	var dq ifDqblk
+	if err := quotactl(qGetQuota, device, id, &dq); err != nil {
+		return 0, fmt.Errorf("Failed to get quota of project %d on %s: %v", id, device, err)
+	}
+	return dq.curSpace, nil
+}
+
+// quotactl runs a project quota command on the filesystem on device
+func quotactl(cmd int, device string, id uint32, dq *ifDqblk) error {
+	special, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota),
+		uintptr(unsafe.Pointer(special)), uintptr(id), uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno == syscall.ESRCH {
+		return fmt.Errorf("project quota is not enabled, mount with %s", ProjectQuotaOption)
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds filesystem trim, which discards blocks unused by the
+// filesystem so thin provisioned VMDKs can give the space back to the
+// datastore, see FITRIM in fstrim(8).
+
+package fs
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"syscall"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const fiTrim = 0xC0185879 // FITRIM ioctl, _IOWR('X', 121, struct fstrim_range)
+
+// fstrimRange - struct fstrim_range from linux/fs.h
+type fstrimRange struct {
+	start  uint64
+	len    uint64
+	minLen uint64
+}
+
+// Trim discards unused blocks of the filesystem mounted at mountPoint and
+// returns the number of bytes trimmed
+func Trim(logger *log.Entry, mountPoint string) (uint64, error) {
+	f, err := os.Open(mountPoint)
+	if err != nil {
+		return 0, fmt.Errorf("Trim of %s failed: %v", mountPoint, err)
+	}
+	defer f.Close()
+
+	// the whole filesystem, the kernel reports the trimmed bytes in len
+	r := fstrimRange{start: 0, len: math.MaxUint64, minLen: 0}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fiTrim, uintptr(unsafe.Pointer(&r)))
+	if errno != 0 {
+		if errno == syscall.EOPNOTSUPP || errno == syscall.ENOTTY {
+			return 0, fmt.Errorf("Trim of %s failed: the filesystem or disk doesn't support discard", mountPoint)
+		}
+		return 0, fmt.Errorf("Trim of %s failed: %v", mountPoint, errno)
+	}
+
+	logger.WithFields(log.Fields{"mountpoint": mountPoint, "bytes": r.len}).Info("Filesystem trimmed ")
+	return r.len, nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds device arrival detection using kernel uevents received
+// over netlink, see NETLINK_KOBJECT_UEVENT in netlink(7).
+//
+// The watcher is created before the attach so no event is missed. Devices
+// which arrived before the watcher, or whose events were dropped, are found
+// by polling between events.
+
+package fs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
+)
+
+const (
+	ueventKernelGroup = 1                      // multicast group of kernel uevents
+	ueventBufSize     = 64 * 1024              // max size of a single uevent
+	ueventRcvBufSize  = 4 * 1024 * 1024        // events queue up while ESX attaches the disk
+	devPollInterval   = 500 * time.Millisecond // recheck for the device when no events arrive
+)
+
+// errDevWaitTimeout is returned when the device didn't show up in time
+var errDevWaitTimeout = fmt.Errorf("Timed out after %v waiting for device", devWaitTimeout)
+
+// DevWatcher listens to kernel device events
+type DevWatcher struct {
+	fd int
+}
+
+// devMatcher returns the device node if env describes the awaited device,
+// or "" otherwise. env is nil when polling for the device.
+type devMatcher func(env map[string]string) string
+
+// DevAttachWaitPrep creates a watcher for disk events. It must be created
+// before the attach and closed by the caller.
+func DevAttachWaitPrep(logger *log.Entry) (*DevWatcher, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		logger.WithFields(log.Fields{"err": err}).Error("Failed to create uevent socket ")
+		return nil, fmt.Errorf("Failed to create uevent socket: %v", err)
+	}
+
+	// best effort, dropped events are covered by polling
+	syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, ueventRcvBufSize)
+
+	addr := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventKernelGroup}
+	if err = syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		logger.WithFields(log.Fields{"err": err}).Error("Failed to bind uevent socket ")
+		return nil, fmt.Errorf("Failed to bind uevent socket: %v", err)
+	}
+	return &DevWatcher{fd: fd}, nil
+}
+
+// Close stops listening to device events
+func (w *DevWatcher) Close() {
+	if w.fd >= 0 {
+		syscall.Close(w.fd)
+		w.fd = -1
+	}
+}
+
+// DevAttachWait waits for the disk attached at volDev and returns its device node.
+// The disk is looked up by its UUID if the server reports it, otherwise by
+// the controller and unit.
+func DevAttachWait(logger *log.Entry, w *DevWatcher, volDev *VolumeDevSpec) (string, error) {
+	match, err := newDevMatcher(volDev)
+	if err != nil {
+		logger.WithFields(log.Fields{"volDev": *volDev, "err": err}).Error("Failed to get device path ")
+		return "", err
+	}
+
+	start := time.Now()
+	device, err := w.wait(logger, match)
+	observeAttachWait(start, err)
+	if err != nil {
+		logger.WithFields(
+			log.Fields{"volDev": *volDev, "err": err},
+		).Error("Attached device not found ")
+		return "", err
+	}
+
+	logger.WithFields(log.Fields{"volDev": *volDev, "device": device}).Info("Scan complete ")
+	return device, nil
+}
+
+// observeAttachWait records the duration and result of a device wait
+func observeAttachWait(start time.Time, err error) {
+	result := "found"
+	if err == errDevWaitTimeout {
+		result = "timeout"
+		metrics.AttachWaitTimeouts.Inc()
+	} else if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.AttachWaitDuration.Observe(time.Since(start).Seconds(), result)
+}
+
+// newDevMatcher returns a matcher for the disk attached at volDev
+func newDevMatcher(volDev *VolumeDevSpec) (devMatcher, error) {
+	if volDev.DiskUUID != "" {
+		return func(env map[string]string) string {
+			if env == nil {
+				return findDiskByUUID(volDev.DiskUUID)
+			}
+			if isDiskAdd(env) && matchDiskID(diskWWID(filepath.Join("/sys", env["DEVPATH"])), volDev.DiskUUID) {
+				return filepath.Join("/dev", env["DEVNAME"])
+			}
+			return ""
+		}, nil
+	}
+
+	locator, err := newDevLocator(volDev)
+	if err != nil {
+		return nil, err
+	}
+	return func(env map[string]string) string {
+		if env == nil {
+			return locator.find()
+		}
+		if isDiskAdd(env) && locator.match(env) {
+			return filepath.Join("/dev", env["DEVNAME"])
+		}
+		return ""
+	}, nil
+}
+
+// wait for a device accepted by match, for at most devWaitTimeout
+func (w *DevWatcher) wait(logger *log.Entry, match devMatcher) (string, error) {
+	buf := make([]byte, ueventBufSize)
+	deadline := time.Now().Add(devWaitTimeout)
+	poll := true
+	for {
+		if poll {
+			if device := match(nil); device != "" {
+				return device, nil
+			}
+			poll = false
+		}
+
+		timeout := deadline.Sub(time.Now())
+		if timeout <= 0 {
+			return "", errDevWaitTimeout
+		}
+		if timeout > devPollInterval {
+			timeout = devPollInterval
+		}
+		tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(w.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return "", fmt.Errorf("Failed to set uevent socket timeout: %v", err)
+		}
+
+		n, from, err := syscall.Recvfrom(w.fd, buf, 0)
+		switch err {
+		case nil:
+		case syscall.EAGAIN, syscall.EINTR:
+			poll = true
+			continue
+		case syscall.ENOBUFS:
+			logger.Warning("Device events were dropped, checking for the device ")
+			poll = true
+			continue
+		default:
+			return "", fmt.Errorf("Failed to receive device events: %v", err)
+		}
+
+		// only trust events sent by the kernel
+		if sa, ok := from.(*syscall.SockaddrNetlink); !ok || sa.Pid != 0 {
+			continue
+		}
+		env := parseUevent(buf[:n])
+		logger.Debug("uevent: ", env)
+		if device := match(env); device != "" {
+			return device, nil
+		}
+	}
+}
+
+// parseUevent parses a kernel uevent message in format:
+// add@/devices/...\0ACTION=add\0DEVPATH=/devices/...\0SUBSYSTEM=block\0...
+func parseUevent(msg []byte) map[string]string {
+	env := make(map[string]string)
+	fields := strings.Split(string(msg), "\x00")
+	for _, field := range fields[1:] {
+		if i := strings.Index(field, "="); i > 0 {
+			env[field[:i]] = field[i+1:]
+		}
+	}
+	return env
+}
+
+// isDiskAdd checks if the event reports a new disk
+func isDiskAdd(env map[string]string) bool {
+	return env["ACTION"] == "add" && env["SUBSYSTEM"] == "block" &&
+		env["DEVTYPE"] == "disk" && env["DEVNAME"] != ""
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const diskDevPath = "/devices/pci0000:00/0000:00:15.0/0000:03:00.0/host2/target2:0:1/2:0:1:0/block/sdb"
+
+func TestParseUevent(t *testing.T) {
+	msg := "add@" + diskDevPath + "\x00ACTION=add\x00DEVPATH=" + diskDevPath +
+		"\x00SUBSYSTEM=block\x00MAJOR=8\x00MINOR=16\x00DEVNAME=sdb\x00DEVTYPE=disk\x00SEQNUM=2271\x00"
+	env := parseUevent([]byte(msg))
+	assert.Equal(t, "add", env["ACTION"])
+	assert.Equal(t, diskDevPath, env["DEVPATH"])
+	assert.Equal(t, "sdb", env["DEVNAME"])
+	assert.True(t, isDiskAdd(env))
+
+	env["DEVTYPE"] = "partition"
+	assert.False(t, isDiskAdd(env), "Partitions are not disks")
+}
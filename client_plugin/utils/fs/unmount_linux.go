@@ -0,0 +1,247 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file holds the safe unmount routine. The filesystem is synced and
+// unmounted, retrying while it is busy. If it stays busy the processes
+// holding it are reported, and the unmount is escalated to a forced and a
+// lazy (detached) unmount if the policy allows.
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+const (
+	procPath = "/proc"
+
+	defaultUnmountRetries       = 5
+	defaultUnmountRetryInterval = 1 * time.Second
+)
+
+// UnmountPolicy - how hard to try unmounting a busy filesystem
+type UnmountPolicy struct {
+	Retries       int           // unmount attempts before escalating
+	RetryInterval time.Duration // wait between attempts
+	Force         bool          // escalate to MNT_FORCE
+	Lazy          bool          // escalate to MNT_DETACH, the last resort
+}
+
+// DefaultUnmountPolicy - retry, but never force or lazy unmount
+func DefaultUnmountPolicy() UnmountPolicy {
+	return UnmountPolicy{
+		Retries:       defaultUnmountRetries,
+		RetryInterval: defaultUnmountRetryInterval,
+	}
+}
+
+// Holder - a process keeping a filesystem busy
+type Holder struct {
+	Pid     int
+	Command string
+	Use     string // e.g. "fd /data/file", "cwd" or "mount /var/lib/data"
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("%s(%d) %s", h.Command, h.Pid, h.Use)
+}
+
+// BusyError - the filesystem stays in use after the unmount attempts
+type BusyError struct {
+	MountPoint string
+	Detached   bool // lazily unmounted, but still in use
+	Holders    []Holder
+}
+
+func (e *BusyError) Error() string {
+	state := "busy"
+	if e.Detached {
+		state = "detached but still in use"
+	}
+	return fmt.Sprintf("Filesystem at %s is %s, held by %v", e.MountPoint, state, e.Holders)
+}
+
+// SafeUnmount syncs and unmounts the filesystem at mountPoint per policy.
+// Returns BusyError if the filesystem can't be unmounted, or is still used
+// after a lazy unmount, so the disk must not be detached.
+func SafeUnmount(logger *log.Entry, mountPoint string, policy UnmountPolicy) error {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(mountPoint, &stat); err != nil {
+		return fmt.Errorf("Unmount device at %s failed: %s", mountPoint, err)
+	}
+	dev := stat.Dev
+
+	// flush dirty data first, so a forced unmount or detach loses nothing
+	syscall.Sync()
+
+	err := unmountRetry(logger, mountPoint, policy)
+	if err == nil {
+		return nil
+	}
+	if err == syscall.EINVAL {
+		logger.WithFields(log.Fields{"mountpoint": mountPoint}).Warning("Not mounted, skipping unmount ")
+		return nil
+	}
+	if err != syscall.EBUSY {
+		return fmt.Errorf("Unmount device at %s failed: %s", mountPoint, err)
+	}
+
+	holders := FindHolders(dev)
+	logger.WithFields(
+		log.Fields{"mountpoint": mountPoint, "holders": holders},
+	).Warning("Filesystem is busy ")
+
+	if policy.Force {
+		logger.WithFields(log.Fields{"mountpoint": mountPoint}).Warning("Forcing unmount ")
+		if err = syscall.Unmount(mountPoint, syscall.MNT_FORCE); err == nil {
+			return nil
+		}
+	}
+	if !policy.Lazy {
+		return &BusyError{MountPoint: mountPoint, Holders: holders}
+	}
+
+	logger.WithFields(log.Fields{"mountpoint": mountPoint}).Warning("Lazy unmount ")
+	if err = syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Lazy unmount of device at %s failed: %s", mountPoint, err)
+	}
+	// the filesystem lives on until the last file is closed
+	if holders = FindHolders(dev); len(holders) > 0 {
+		return &BusyError{MountPoint: mountPoint, Detached: true, Holders: holders}
+	}
+	return nil
+}
+
+// unmountRetry unmounts, retrying while the filesystem is busy
+func unmountRetry(logger *log.Entry, mountPoint string, policy UnmountPolicy) error {
+	var err error
+	for attempt := 0; attempt <= policy.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.RetryInterval)
+		}
+		err = syscall.Unmount(mountPoint, 0)
+		if err != syscall.EBUSY {
+			return err
+		}
+		logger.WithFields(
+			log.Fields{"mountpoint": mountPoint, "attempt": attempt + 1},
+		).Debug("Filesystem busy, retrying unmount ")
+	}
+	return err
+}
+
+// FindHolders returns processes using files on the filesystem dev, or
+// having it mounted in another mount namespace (reported once per namespace)
+func FindHolders(dev uint64) []Holder {
+	var holders []Holder
+	pids, _ := filepath.Glob(filepath.Join(procPath, "[0-9]*"))
+	// mounts in the plugin's own namespace are the ones being unmounted
+	namespaces := make(map[string]bool)
+	if ns, err := os.Readlink(filepath.Join(procPath, "self", "ns", "mnt")); err == nil {
+		namespaces[ns] = true
+	}
+
+	for _, dir := range pids {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		command := readCommand(dir)
+
+		for _, link := range []string{"cwd", "root", "exe"} {
+			if onDevice(filepath.Join(dir, link), dev) {
+				holders = append(holders, Holder{Pid: pid, Command: command, Use: link})
+			}
+		}
+		fds, _ := ioutil.ReadDir(filepath.Join(dir, "fd"))
+		for _, fd := range fds {
+			path := filepath.Join(dir, "fd", fd.Name())
+			if onDevice(path, dev) {
+				target, _ := os.Readlink(path)
+				holders = append(holders, Holder{Pid: pid, Command: command, Use: "fd " + target})
+			}
+		}
+
+		ns, err := os.Readlink(filepath.Join(dir, "ns", "mnt"))
+		if err != nil || namespaces[ns] {
+			continue
+		}
+		namespaces[ns] = true
+		for _, mountPoint := range mountsOfDevice(filepath.Join(dir, "mountinfo"), dev) {
+			holders = append(holders, Holder{Pid: pid, Command: command, Use: "mount " + mountPoint})
+		}
+	}
+	return holders
+}
+
+// onDevice checks if the file path resolves to is on the filesystem dev
+func onDevice(path string, dev uint64) bool {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false
+	}
+	return stat.Dev == dev
+}
+
+// readCommand returns the command name of the process in /proc dir
+func readCommand(dir string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// mountsOfDevice returns mount points of the filesystem dev listed in a
+// mountinfo file. Mounts are matched by device number, as the source of a
+// mount may be any path of the device, e.g. /dev/mapper/vdvs-vol1 for
+// /dev/dm-0.
+func mountsOfDevice(mountInfoFile string, dev uint64) []string {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	entries, err := plugin_utils.ParseMountInfo(f)
+	if err != nil {
+		return nil
+	}
+	var mountPoints []string
+	for _, entry := range entries {
+		if entry.Major == major(dev) && entry.Minor == minor(dev) {
+			mountPoints = append(mountPoints, entry.MountPoint)
+		}
+	}
+	return mountPoints
+}
+
+// major and minor of a device number, see makedev(3)
+func major(dev uint64) uint32 {
+	return uint32(((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000))
+}
+
+func minor(dev uint64) uint32 {
+	return uint32((dev & 0xff) | ((dev >> 12) & 0xffffff00))
+}
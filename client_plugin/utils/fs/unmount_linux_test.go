@@ -0,0 +1,54 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceNumber(t *testing.T) {
+	assert.Equal(t, uint32(8), major(0x810))
+	assert.Equal(t, uint32(16), minor(0x810))
+	// minor above 255 is split, makedev(259, 65537)
+	assert.Equal(t, uint32(259), major(0x10010301))
+	assert.Equal(t, uint32(65537), minor(0x10010301))
+}
+
+func TestMountsOfDevice(t *testing.T) {
+	f, err := ioutil.TempFile("", "mountinfo")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("20 1 8:1 / / rw,relatime - ext4 /dev/sda1 rw\n" +
+		"40 20 253:0 / /var/lib/docker/plugins/x/rootfs/mnt/vmdk/vol1 rw - ext4 /dev/mapper/vdvs-vol1 rw\n" +
+		"41 20 253:0 /dir /data rw - ext4 /dev/dm-0 rw\n")
+	f.Close()
+
+	// makedev(253, 0) and makedev(253, 1)
+	assert.Equal(t, []string{"/var/lib/docker/plugins/x/rootfs/mnt/vmdk/vol1", "/data"},
+		mountsOfDevice(f.Name(), 0xfd00))
+	assert.Equal(t, 0, len(mountsOfDevice(f.Name(), 0xfd01)))
+}
+
+func TestBusyError(t *testing.T) {
+	err := &BusyError{MountPoint: "/mnt/vmdk/vol1",
+		Holders: []Holder{{Pid: 42, Command: "bash", Use: "cwd"}}}
+	assert.Equal(t, "Filesystem at /mnt/vmdk/vol1 is busy, held by [bash(42) cwd]", err.Error())
+	err.Detached = true
+	assert.Contains(t, err.Error(), "detached but still in use")
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprovider
+
+// Key providers return keys of encrypted volumes by key ID. Keys are read
+// from a file, or from a directory holding a file per key ID, or printed
+// by an external command, e.g. a client of a key management service,
+// which gets the key ID as its last argument. Key IDs are made of letters,
+// digits, '.', '_' and '-', and don't start with '-'.
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// keyIDPattern - valid key IDs, which name key files and are passed to
+// key commands
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._][A-Za-z0-9._-]*$`)
+
+// Provider returns volume encryption keys
+type Provider interface {
+	GetKey(keyID string) ([]byte, error)
+}
+
+// NewProvider returns a provider reading keys from keyFile, or running
+// keyCommand. Returns nil if neither is configured.
+func NewProvider(keyFile string, keyCommand string) (Provider, error) {
+	if keyFile != "" && keyCommand != "" {
+		return nil, fmt.Errorf("Configure either a key file or a key command, not both")
+	}
+	if keyFile != "" {
+		return &fileProvider{path: keyFile}, nil
+	}
+	if keyCommand != "" {
+		args := strings.Fields(keyCommand)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("Invalid key command %q", keyCommand)
+		}
+		return &commandProvider{command: args[0], args: args[1:]}, nil
+	}
+	return nil, nil
+}
+
+// fileProvider - keys in a file, or in files named by key ID in a directory
+type fileProvider struct {
+	path string
+}
+
+func (p *fileProvider) GetKey(keyID string) ([]byte, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	path := p.path
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key %s: %v", keyID, err)
+	}
+	if stat.IsDir() {
+		path = filepath.Join(path, keyID)
+	}
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key %s: %v", keyID, err)
+	}
+	return checkKey(keyID, key)
+}
+
+// commandProvider - keys printed by a command
+type commandProvider struct {
+	command string
+	args    []string
+}
+
+func (p *commandProvider) GetKey(keyID string) ([]byte, error) {
+	if err := checkKeyID(keyID); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.command, append(p.args, keyID)...)
+	cmd.Stderr = &stderr
+	key, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get key %s from %s: %v %s", keyID, p.command, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	// drop the newline ending the output, the key may end with others
+	if bytes.HasSuffix(key, []byte("\n")) {
+		key = bytes.TrimSuffix(key[:len(key)-1], []byte("\r"))
+	}
+	return checkKey(keyID, key)
+}
+
+// checkKeyID rejects key IDs which could name other files or be taken
+// for options of the key command
+func checkKeyID(keyID string) error {
+	if !keyIDPattern.MatchString(keyID) || keyID == "." || keyID == ".." {
+		return fmt.Errorf("Invalid key ID %q", keyID)
+	}
+	return nil
+}
+
+// checkKey rejects empty keys
+func checkKey(keyID string, key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("Key %s is empty", keyID)
+	}
+	return key, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyprovider_test
+
+// Test reading keys from files and commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/keyprovider"
+)
+
+func TestNoProvider(t *testing.T) {
+	p, err := keyprovider.NewProvider("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, p)
+
+	_, err = keyprovider.NewProvider("/etc/key", "echo")
+	assert.NotNil(t, err, "Both file and command")
+
+	_, err = keyprovider.NewProvider("", "  ")
+	assert.NotNil(t, err, "Blank command")
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "gold"), []byte("secret"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "silver.1"), []byte("secret\r\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "empty"), []byte{}, 0600)
+
+	// directory of keys by ID
+	p, _ := keyprovider.NewProvider(dir, "")
+	key, err := p.GetKey("gold")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(key))
+	key, err = p.GetKey("silver.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret\r\n", string(key), "Key files are used as they are")
+	_, err = p.GetKey("missing")
+	assert.NotNil(t, err)
+	_, err = p.GetKey("empty")
+	assert.NotNil(t, err, "Empty key")
+	for _, keyID := range []string{"../gold", "..", "", "-gold", "gold key"} {
+		_, err = p.GetKey(keyID)
+		assert.NotNil(t, err, "Key ID %q", keyID)
+	}
+
+	// single key file
+	p, _ = keyprovider.NewProvider(filepath.Join(dir, "gold"), "")
+	key, err = p.GetKey("any")
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", string(key))
+	_, err = p.GetKey("../any")
+	assert.NotNil(t, err, "Key ID with path")
+}
+
+func TestCommandProvider(t *testing.T) {
+	p, _ := keyprovider.NewProvider("", "echo key-for")
+	key, err := p.GetKey("gold")
+	assert.Nil(t, err)
+	assert.Equal(t, "key-for gold", string(key))
+	_, err = p.GetKey("--help")
+	assert.NotNil(t, err, "Key ID taken for an option")
+
+	// only the newline ending the output is dropped
+	p, _ = keyprovider.NewProvider("", `printf %s\n\n`)
+	key, err = p.GetKey("gold")
+	assert.Nil(t, err)
+	assert.Equal(t, "gold\n", string(key))
+
+	p, _ = keyprovider.NewProvider("", "false")
+	_, err = p.GetKey("gold")
+	assert.NotNil(t, err)
+}
@@ -16,70 +16,176 @@
 // * [Logrus Formatter](https://github.com/Sirupsen/logrus#formatters) according to VMware CNA
 // * storage team specifications. The `appendKeyValue` and `needsQuoting` functions are copied
 // * from the implementation of the TextFormatter in Logrus.
+// *
+// * JSONFormatter and LogfmtFormatter write the same entries for log shippers.
+// * All formatters write fields sorted by key and RFC3339Nano timestamps.
 
 package log_formatter
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"sort"
 	"strings"
+	"time"
 )
 
+const (
+	// FormatVmware - free text lines followed by fields, the default
+	FormatVmware = "vmware"
+	// FormatJSON - a JSON object per line
+	FormatJSON = "json"
+	// FormatLogfmt - key=value pairs per line
+	FormatLogfmt = "logfmt"
+
+	timestampFormat = time.RFC3339Nano
+)
+
+// NewFormatter returns the formatter for a log format, the vmware
+// formatter if format is empty
+func NewFormatter(format string) (log.Formatter, error) {
+	switch format {
+	case "", FormatVmware:
+		return new(VmwareFormatter), nil
+	case FormatJSON:
+		return new(JSONFormatter), nil
+	case FormatLogfmt:
+		return new(LogfmtFormatter), nil
+	}
+	return nil, fmt.Errorf("Unknown log format %s, use one of %s, %s, %s",
+		format, FormatVmware, FormatJSON, FormatLogfmt)
+}
+
 // VmwareFormatter struct
 type VmwareFormatter struct{}
 
 // Format log messages
 func (f *VmwareFormatter) Format(entry *log.Entry) ([]byte, error) {
 	b := &bytes.Buffer{}
-	fmt.Fprint(b, entry.Time.String())
+	b.WriteString(entry.Time.Format(timestampFormat))
 	b.WriteByte(' ')
 	b.WriteByte('[')
 	fmt.Fprint(b, strings.ToUpper(entry.Level.String()))
 	b.WriteByte(']')
 	b.WriteByte(' ')
 	b.WriteString(entry.Message)
+	for _, key := range sortedKeys(entry.Data) {
+		appendKeyValue(b, key, entry.Data[key])
+		b.WriteByte(' ')
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// JSONFormatter struct
+type JSONFormatter struct{}
+
+// Format log messages as JSON objects, fields clashing with the time,
+// level and msg keys are prefixed with "fields."
+func (f *JSONFormatter) Format(entry *log.Entry) ([]byte, error) {
+	data := make(map[string]interface{}, len(entry.Data)+3)
+	for key, value := range entry.Data {
+		key = fieldKey(key)
+		if err, ok := value.(error); ok {
+			// errors are ignored by encoding/json
+			value = err.Error()
+		}
+		data[key] = value
+	}
+	data["time"] = entry.Time.Format(timestampFormat)
+	data["level"] = entry.Level.String()
+	data["msg"] = strings.TrimSpace(entry.Message)
+
+	// encoding/json sorts map keys
+	serialized, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal fields to JSON, %v", err)
+	}
+	return append(serialized, '\n'), nil
+}
+
+// LogfmtFormatter struct
+type LogfmtFormatter struct{}
+
+// Format log messages as logfmt lines, time, level and msg come first,
+// fields clashing with them are prefixed with "fields."
+func (f *LogfmtFormatter) Format(entry *log.Entry) ([]byte, error) {
+	b := &bytes.Buffer{}
+	appendKeyValue(b, "time", entry.Time.Format(timestampFormat))
+	b.WriteByte(' ')
+	appendKeyValue(b, "level", entry.Level.String())
+	b.WriteByte(' ')
+	appendKeyValue(b, "msg", strings.TrimSpace(entry.Message))
+	fields := make(log.Fields, len(entry.Data))
 	for key, value := range entry.Data {
-		f.appendKeyValue(b, key, value)
+		fields[fieldKey(key)] = value
+	}
+	for _, key := range sortedKeys(fields) {
+		b.WriteByte(' ')
+		appendKeyValue(b, key, fields[key])
 	}
 	b.WriteByte('\n')
 	return b.Bytes(), nil
 }
 
+// fieldKey returns the key of a field, prefixed if it clashes with
+// the keys of the entry
+func fieldKey(key string) string {
+	if key == "time" || key == "level" || key == "msg" {
+		return "fields." + key
+	}
+	return key
+}
+
+// sortedKeys returns the keys of fields in order
+func sortedKeys(fields log.Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// needsQuoting returns true unless text is made of letters, digits,
+// '-' and '.' only
 func needsQuoting(text string) bool {
+	if text == "" {
+		return true
+	}
 	for _, ch := range text {
 		if !((ch >= 'a' && ch <= 'z') ||
 			(ch >= 'A' && ch <= 'Z') ||
 			(ch >= '0' && ch <= '9') ||
 			ch == '-' || ch == '.') {
-			return false
+			return true
 		}
 	}
-	return true
+	return false
 }
 
-func (f *VmwareFormatter) appendKeyValue(b *bytes.Buffer, key string, value interface{}) {
+func appendKeyValue(b *bytes.Buffer, key string, value interface{}) {
 
 	b.WriteString(key)
 	b.WriteByte('=')
 
 	switch value := value.(type) {
 	case string:
-		if needsQuoting(value) {
-			b.WriteString(value)
-		} else {
-			fmt.Fprintf(b, "%q", value)
-		}
+		appendString(b, value)
 	case error:
-		errmsg := value.Error()
-		if needsQuoting(errmsg) {
-			b.WriteString(errmsg)
-		} else {
-			fmt.Fprintf(b, "%q", value)
-		}
+		appendString(b, value.Error())
 	default:
-		fmt.Fprint(b, value)
+		appendString(b, fmt.Sprint(value))
 	}
+}
 
-	b.WriteByte(' ')
+// appendString writes text, quoted if needed
+func appendString(b *bytes.Buffer, text string) {
+	if needsQuoting(text) {
+		fmt.Fprintf(b, "%q", text)
+	} else {
+		b.WriteString(text)
+	}
 }
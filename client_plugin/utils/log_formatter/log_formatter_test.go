@@ -0,0 +1,85 @@
+// Copyright 2016 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log_formatter
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntry() *log.Entry {
+	entry := log.NewEntry(log.New())
+	entry.Time = time.Date(2017, 6, 1, 12, 30, 45, 123456789, time.UTC)
+	entry.Level = log.InfoLevel
+	entry.Message = "Mounted volume "
+	entry.Data = log.Fields{
+		"name":     "vol1@datastore1",
+		"refcount": 2,
+		"err":      errors.New("device busy"),
+		"level":    "clash",
+	}
+	return entry
+}
+
+func TestVmwareFormatter(t *testing.T) {
+	out, err := new(VmwareFormatter).Format(testEntry())
+	assert.Nil(t, err)
+	assert.Equal(t, "2017-06-01T12:30:45.123456789Z [INFO] Mounted volume "+
+		"err=\"device busy\" level=clash name=\"vol1@datastore1\" refcount=2 \n", string(out))
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	out, err := new(LogfmtFormatter).Format(testEntry())
+	assert.Nil(t, err)
+	assert.Equal(t, "time=\"2017-06-01T12:30:45.123456789Z\" level=info msg=\"Mounted volume\" "+
+		"err=\"device busy\" fields.level=clash name=\"vol1@datastore1\" refcount=2\n", string(out))
+}
+
+func TestJSONFormatter(t *testing.T) {
+	out, err := new(JSONFormatter).Format(testEntry())
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"err\":\"device busy\",\"fields.level\":\"clash\",\"level\":\"info\","+
+		"\"msg\":\"Mounted volume\",\"name\":\"vol1@datastore1\",\"refcount\":2,"+
+		"\"time\":\"2017-06-01T12:30:45.123456789Z\"}\n", string(out))
+
+	var fields map[string]interface{}
+	assert.Nil(t, json.Unmarshal(out, &fields))
+}
+
+func TestNeedsQuoting(t *testing.T) {
+	assert.False(t, needsQuoting("vol-1.vmdk"))
+	assert.True(t, needsQuoting("vol1@datastore1"))
+	assert.True(t, needsQuoting("two words"))
+	assert.True(t, needsQuoting(""))
+}
+
+func TestNewFormatter(t *testing.T) {
+	formatter, err := NewFormatter("")
+	assert.Nil(t, err)
+	assert.IsType(t, &VmwareFormatter{}, formatter)
+	formatter, err = NewFormatter(FormatJSON)
+	assert.Nil(t, err)
+	assert.IsType(t, &JSONFormatter{}, formatter)
+	formatter, err = NewFormatter(FormatLogfmt)
+	assert.Nil(t, err)
+	assert.IsType(t, &LogfmtFormatter{}, formatter)
+	_, err = NewFormatter("xml")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,335 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics keeps plugin metrics and serves them in the Prometheus
+// text exposition format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// Counters and histograms are updated where things happen, gauges are
+// set at scrape time by collectors registered with RegisterCollector.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	metricsPath = "/metrics"
+	contentType = "text/plain; version=0.0.4"
+)
+
+// metric is a metric family written to the exposition
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMtx sync.Mutex
+	registry    []metric
+	collectors  []func()
+)
+
+// register adds m to the metrics served
+func register(m metric) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	registry = append(registry, m)
+}
+
+// RegisterCollector adds a function setting gauges, run on each scrape
+func RegisterCollector(collect func()) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	collectors = append(collectors, collect)
+}
+
+// family - name, help and labels shared by the series of a metric
+type family struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// key joins label values into a series key
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// labelPairs formats the labels of series key, with extra appended
+func (f *family) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, value := range strings.Split(key, "\x00") {
+			pairs = append(pairs, f.labels[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// header writes the HELP and TYPE lines
+func (f *family) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, typ)
+}
+
+// sortedKeys returns the series keys of a map in a stable order
+func sortedKeys(keys []string) []string {
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec - counters partitioned by labels
+type CounterVec struct {
+	family
+	mtx    sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{family: family{name, help, labels}, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc increments the counter with label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter with label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.values[key] += v
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.header(w, "counter")
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatValue(c.values[key]))
+	}
+}
+
+// GaugeVec - gauges partitioned by labels
+type GaugeVec struct {
+	family
+	mtx    sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec creates and registers a gauge
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{family: family{name, help, labels}, values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+// Set sets the gauge with label values to v
+func (g *GaugeVec) Set(v float64, values ...string) {
+	key := g.key(values)
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.values[key] = v
+}
+
+// Reset drops all series, e.g. before a collector sets the current ones
+func (g *GaugeVec) Reset() {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.values = make(map[string]float64)
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.header(w, "gauge")
+	keys := make([]string, 0, len(g.values))
+	for key := range g.values {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatValue(g.values[key]))
+	}
+}
+
+// histogram - a single series of a HistogramVec
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec - histograms partitioned by labels
+type HistogramVec struct {
+	family
+	buckets []float64 // upper bounds, ascending, +Inf is implicit
+	mtx     sync.Mutex
+	series  map[string]*histogram
+}
+
+// NewHistogramVec creates and registers a histogram with bucket upper bounds
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{family: family{name, help, labels}, buckets: buckets,
+		series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe adds v to the histogram with label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.header(w, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	for _, key := range sortedKeys(keys) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), s.count)
+	}
+}
+
+// formatValue formats a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp escapes a help text
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// Write runs the collectors and writes all metrics to w
+func Write(w io.Writer) {
+	registryMtx.Lock()
+	defer registryMtx.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	for _, m := range registry {
+		m.write(w)
+	}
+}
+
+// handler serves the metrics
+func handler(writer http.ResponseWriter, req *http.Request) {
+	var buf bytes.Buffer
+	Write(&buf)
+	writer.Header().Set("Content-Type", contentType)
+	writer.Write(buf.Bytes())
+}
+
+// listener of the metrics server, nil if not serving
+var (
+	listenerMtx sync.Mutex
+	listener    net.Listener
+)
+
+// Serve starts serving metrics at http://<address>/metrics in the background
+func Serve(address string) error {
+	listenerMtx.Lock()
+	defer listenerMtx.Unlock()
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	listener = l
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, handler)
+	log.WithFields(log.Fields{"address": address}).Info("Serving metrics ")
+	go func() {
+		err := http.Serve(l, mux)
+		log.WithFields(log.Fields{"address": address, "err": err}).Info("Metrics listener stopped ")
+	}()
+	return nil
+}
+
+// Stop stops serving metrics
+func Stop() {
+	listenerMtx.Lock()
+	defer listenerMtx.Unlock()
+	if listener != nil {
+		listener.Close()
+		listener = nil
+	}
+}
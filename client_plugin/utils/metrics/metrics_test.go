@@ -0,0 +1,86 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape() string {
+	var buf bytes.Buffer
+	Write(&buf)
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounterVec("test_requests_total", "Test requests.", "cmd", "result")
+	c.Inc("get", ResultSuccess)
+	c.Inc("get", ResultSuccess)
+	c.Add(3, "attach", ResultError)
+
+	out := scrape()
+	assert.Contains(t, out, "# HELP test_requests_total Test requests.\n# TYPE test_requests_total counter\n")
+	assert.Contains(t, out, "test_requests_total{cmd=\"attach\",result=\"error\"} 3\n"+
+		"test_requests_total{cmd=\"get\",result=\"success\"} 2\n")
+}
+
+func TestCounterWithoutLabels(t *testing.T) {
+	c := NewCounterVec("test_timeouts_total", "Test timeouts.")
+	c.Inc()
+	assert.Contains(t, scrape(), "\ntest_timeouts_total 1\n")
+}
+
+func TestGaugeCollector(t *testing.T) {
+	g := NewGaugeVec("test_refcount", "Test refcounts.", "volume")
+	counts := map[string]float64{"vol1@ds": 2}
+	RegisterCollector(func() {
+		g.Reset()
+		for vol, count := range counts {
+			g.Set(count, vol)
+		}
+	})
+	assert.Contains(t, scrape(), "test_refcount{volume=\"vol1@ds\"} 2\n")
+
+	counts = map[string]float64{"vol2@ds": 1}
+	out := scrape()
+	assert.NotContains(t, out, "vol1@ds")
+	assert.Contains(t, out, "test_refcount{volume=\"vol2@ds\"} 1\n")
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogramVec("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "cmd")
+	h.Observe(0.05, "get")
+	h.Observe(0.5, "get")
+	h.Observe(5, "get")
+
+	assert.Contains(t, scrape(), "# TYPE test_duration_seconds histogram\n"+
+		"test_duration_seconds_bucket{cmd=\"get\",le=\"0.1\"} 1\n"+
+		"test_duration_seconds_bucket{cmd=\"get\",le=\"1\"} 2\n"+
+		"test_duration_seconds_bucket{cmd=\"get\",le=\"+Inf\"} 3\n"+
+		"test_duration_seconds_sum{cmd=\"get\"} 5.55\n"+
+		"test_duration_seconds_count{cmd=\"get\"} 3\n")
+}
+
+func TestEscaping(t *testing.T) {
+	c := NewCounterVec("test_escaped_total", "Help with \\ and\nnewline.", "name")
+	c.Inc("a\"b\\c\nd")
+
+	out := scrape()
+	assert.Contains(t, out, "# HELP test_escaped_total Help with \\\\ and\\nnewline.\n")
+	assert.Contains(t, out, "test_escaped_total{name=\"a\\\"b\\\\c\\nd\"} 1\n")
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+// Metrics of the plugin
+
+const (
+	// ResultSuccess and ResultError - values of result labels
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+var (
+	// latencyBuckets - bounds in seconds of request latency histograms
+	latencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+	// attachWaitBuckets - bounds in seconds of the attach wait histogram,
+	// up to the device wait timeout of the fs package
+	attachWaitBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10}
+)
+
+var (
+	// EsxRequests - requests to the ESX service by command and result
+	EsxRequests = NewCounterVec("vdvs_esx_requests_total",
+		"Requests to the ESX service by command and result.", "cmd", "result")
+	// EsxRequestDuration - latency of requests to the ESX service
+	EsxRequestDuration = NewHistogramVec("vdvs_esx_request_duration_seconds",
+		"Latency of requests to the ESX service, retries included.", latencyBuckets, "cmd")
+	// EsxRetries - retried requests to the ESX service
+	EsxRetries = NewCounterVec("vdvs_esx_request_retries_total",
+		"Retries of requests to the ESX service by command.", "cmd")
+	// EsxErrors - failed attempts of requests to the ESX service by error class
+	EsxErrors = NewCounterVec("vdvs_esx_request_errors_total",
+		"Failed attempts of requests to the ESX service by command and error class.", "cmd", "class")
+
+	// AttachWaitDuration - time waiting for attached devices to show up
+	AttachWaitDuration = NewHistogramVec("vdvs_attach_wait_duration_seconds",
+		"Time waiting for the device of an attached volume to show up, by result.", attachWaitBuckets, "result")
+	// AttachWaitTimeouts - attached devices which didn't show up in time
+	AttachWaitTimeouts = NewCounterVec("vdvs_attach_wait_timeouts_total",
+		"Attached volumes whose device didn't show up in time.")
+
+	// VolumeOps - Docker volume requests by driver, operation and result
+	VolumeOps = NewCounterVec("vdvs_volume_operations_total",
+		"Docker volume requests by driver, operation and result.", "driver", "operation", "result")
+	// VolumeOpDuration - latency of Docker volume requests
+	VolumeOpDuration = NewHistogramVec("vdvs_volume_operation_duration_seconds",
+		"Latency of Docker volume requests by driver and operation.", latencyBuckets, "driver", "operation")
+
+	// RefCounts - containers using each volume, set by collectors
+	RefCounts = NewGaugeVec("vdvs_volume_refcount",
+		"Containers on this host using a volume.", "volume")
+	// MountedVolumes - volumes mounted by the plugin, set by collectors
+	MountedVolumes = NewGaugeVec("vdvs_mounted_volumes",
+		"Volumes mounted by the plugin on this host.", "driver")
+)
+
+// Result returns the result label value for err
+func Result(err error) string {
+	if err != nil {
+		return ResultError
+	}
+	return ResultSuccess
+}
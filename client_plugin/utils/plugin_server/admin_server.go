@@ -0,0 +1,354 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_server
+
+// Admin interface of the plugin, served on a unix sock of its own so it
+// is never reachable through the Docker plugin sock. Access is limited to
+// root by the permissions of the sock.
+//
+// Endpoints, all answering JSON, errors are returned in the Err field:
+//  GET  /refcounts - plugin health, refcounts and volumes of Docker mount IDs
+//  POST /reconcile - rediscover refcounts from Docker and sync mounts
+//  GET  /loglevel - the log level
+//  PUT  /loglevel - change the log level, body {"Level": "debug"}
+//  GET  /volumes/<name> - refcount, mount and attach state of a volume
+//  POST /volumes/<name>/unmount - drop refcount, unmount and detach a volume
+//  POST /volumes/<name>/detach - detach a volume which is not mounted
+//  POST /volumes/<name>/freeze?timeout=<duration> - freeze a mounted volume,
+//       it is thawed after timeout (e.g. 30s, 5m), timeout is mandatory
+//  POST /volumes/<name>/thaw - thaw a frozen volume
+//  GET  /healthz - 200 while the plugin serves requests
+//  GET  /readyz - 200 if the plugin is ready to serve volumes, 503 otherwise,
+//       with the result of each readiness check
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const (
+	adminVolumesPath   = "/volumes/"
+	adminRefCountsPath = "/refcounts"
+	adminReconcilePath = "/reconcile"
+	adminLogLevelPath  = "/loglevel"
+	adminHealthPath    = "/healthz"
+	adminReadyPath     = "/readyz"
+	adminSockPerm      = 0600
+	adminDirPerm       = 0700
+)
+
+// Freezer is implemented by drivers which can freeze volume filesystems.
+type Freezer interface {
+	// Freeze freezes a volume, thawing it after timeout.
+	Freeze(name string, timeout time.Duration) error
+	// Thaw thaws a frozen volume.
+	Thaw(name string) error
+}
+
+// VolumeAdmin is implemented by drivers which can be managed on the admin
+// interface.
+type VolumeAdmin interface {
+	// RefCountState returns plugin health, refcounts and mount IDs.
+	RefCountState() map[string]interface{}
+	// VolumeState returns the refcount, mount and attach state of a volume.
+	VolumeState(name string) (map[string]interface{}, error)
+	// ForceUnmount drops the refcount of a volume, unmounts and detaches it.
+	ForceUnmount(name string) error
+	// ForceDetach detaches a volume which is not mounted.
+	ForceDetach(name string) error
+	// Reconcile rediscovers refcounts and syncs mounts with them.
+	Reconcile() error
+}
+
+// HealthChecker is implemented by drivers which depend on services or
+// host setup to serve volumes.
+type HealthChecker interface {
+	// ReadyChecks returns the result of each readiness check by name,
+	// nil for passed checks.
+	ReadyChecks() map[string]error
+}
+
+// AdminServer serves the admin interface over a unix sock.
+type AdminServer struct {
+	sockAddr string         // Server's unix sock address
+	driver   *volume.Driver // The driver implementation
+	mux      *http.ServeMux // The HTTP mux
+	listener net.Listener   // The unix sock listener
+}
+
+// adminResponse - body of admin responses without a result
+type adminResponse struct {
+	Err string `json:",omitempty"`
+}
+
+// health - body of /healthz responses
+type health struct {
+	Status string
+}
+
+// readiness - body of /readyz responses, Checks holds "ok" or the error
+// of each check
+type readiness struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+// logLevel - body of log level requests and responses
+type logLevel struct {
+	Level string
+}
+
+// NewAdminServer creates a new instance of AdminServer.
+func NewAdminServer(sockAddr string, driver *volume.Driver) *AdminServer {
+	return &AdminServer{sockAddr: sockAddr, driver: driver, mux: http.NewServeMux()}
+}
+
+// Init starts serving admin requests in the background.
+func (s *AdminServer) Init() error {
+	s.mux.HandleFunc(adminVolumesPath, s.volumeAction)
+	s.mux.HandleFunc(adminRefCountsPath, s.refCounts)
+	s.mux.HandleFunc(adminReconcilePath, s.reconcile)
+	s.mux.HandleFunc(adminLogLevelPath, s.logLevel)
+	s.mux.HandleFunc(adminHealthPath, s.health)
+	s.mux.HandleFunc(adminReadyPath, s.ready)
+
+	if err := os.MkdirAll(filepath.Dir(s.sockAddr), adminDirPerm); err != nil {
+		return err
+	}
+	// a sock left by an earlier instance
+	os.Remove(s.sockAddr)
+	listener, err := listenUnix(s.sockAddr, adminSockPerm)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	log.WithFields(log.Fields{"address": s.sockAddr}).Info("Listening for admin requests on Unix socket ")
+	go func() {
+		log.Info(http.Serve(s.listener, s.mux))
+	}()
+	return nil
+}
+
+// Destroy stops the admin server and removes its sock.
+func (s *AdminServer) Destroy() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	os.Remove(s.sockAddr)
+}
+
+// admin returns the driver as VolumeAdmin, or writes an error if the
+// driver doesn't support it
+func (s *AdminServer) admin(writer http.ResponseWriter, req *http.Request) VolumeAdmin {
+	admin, ok := (*s.driver).(VolumeAdmin)
+	if !ok {
+		adminError(writer, req, http.StatusNotImplemented, fmt.Errorf("Driver does not support %s", req.URL.Path))
+		return nil
+	}
+	return admin
+}
+
+// refCounts serves GET /refcounts
+func (s *AdminServer) refCounts(writer http.ResponseWriter, req *http.Request) {
+	if !checkMethod(writer, req, http.MethodGet) {
+		return
+	}
+	if admin := s.admin(writer, req); admin != nil {
+		adminResult(writer, req, nil, admin.RefCountState())
+	}
+}
+
+// reconcile serves POST /reconcile
+func (s *AdminServer) reconcile(writer http.ResponseWriter, req *http.Request) {
+	if !checkMethod(writer, req, http.MethodPost) {
+		return
+	}
+	if admin := s.admin(writer, req); admin != nil {
+		adminResult(writer, req, admin.Reconcile(), nil)
+	}
+}
+
+// logLevel serves GET and PUT /loglevel
+func (s *AdminServer) logLevel(writer http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet {
+		adminResult(writer, req, nil, logLevel{Level: log.GetLevel().String()})
+		return
+	}
+	if !checkMethod(writer, req, http.MethodPut) {
+		return
+	}
+	var body logLevel
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		adminError(writer, req, http.StatusBadRequest, fmt.Errorf("Invalid request body: %v", err))
+		return
+	}
+	level, err := log.ParseLevel(body.Level)
+	if err != nil {
+		adminError(writer, req, http.StatusBadRequest, err)
+		return
+	}
+	log.WithFields(log.Fields{"from": log.GetLevel().String(), "to": level.String()}).Warning("Changing log level ")
+	log.SetLevel(level)
+	adminResult(writer, req, nil, logLevel{Level: level.String()})
+}
+
+// health serves GET /healthz
+func (s *AdminServer) health(writer http.ResponseWriter, req *http.Request) {
+	if !checkMethod(writer, req, http.MethodGet) {
+		return
+	}
+	adminWrite(writer, req, http.StatusOK, health{Status: "ok"})
+}
+
+// ready serves GET /readyz. Drivers which don't check readiness are ready
+// once the plugin serves requests.
+func (s *AdminServer) ready(writer http.ResponseWriter, req *http.Request) {
+	if !checkMethod(writer, req, http.MethodGet) {
+		return
+	}
+	resp := readiness{Ready: true, Checks: make(map[string]string)}
+	if checker, ok := (*s.driver).(HealthChecker); ok {
+		for name, err := range checker.ReadyChecks() {
+			if err != nil {
+				resp.Ready = false
+				resp.Checks[name] = err.Error()
+			} else {
+				resp.Checks[name] = "ok"
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if !resp.Ready {
+		// not an admin request failure, probes poll until the plugin is ready
+		log.WithFields(log.Fields{"checks": resp.Checks}).Debug("Plugin not ready ")
+		status = http.StatusServiceUnavailable
+	}
+	adminWrite(writer, req, status, resp)
+}
+
+// volumeAction serves GET /volumes/<name> and POST /volumes/<name>/<action>
+func (s *AdminServer) volumeAction(writer http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, adminVolumesPath)
+	name, action := path, ""
+	if slash := strings.Index(path, "/"); slash >= 0 {
+		name, action = path[:slash], path[slash+1:]
+	}
+	if name == "" {
+		adminError(writer, req, http.StatusNotFound, fmt.Errorf("Missing volume name in %s", req.URL.Path))
+		return
+	}
+
+	if action == "" {
+		if !checkMethod(writer, req, http.MethodGet) {
+			return
+		}
+		if admin := s.admin(writer, req); admin != nil {
+			state, err := admin.VolumeState(name)
+			adminResult(writer, req, err, state)
+		}
+		return
+	}
+
+	if !checkMethod(writer, req, http.MethodPost) {
+		return
+	}
+	switch action {
+	case "unmount":
+		if admin := s.admin(writer, req); admin != nil {
+			adminResult(writer, req, admin.ForceUnmount(name), nil)
+		}
+	case "detach":
+		if admin := s.admin(writer, req); admin != nil {
+			adminResult(writer, req, admin.ForceDetach(name), nil)
+		}
+	case "freeze", "thaw":
+		s.freezeAction(writer, req, name, action)
+	default:
+		adminError(writer, req, http.StatusNotFound, fmt.Errorf("Unknown volume action %s", action))
+	}
+}
+
+// freezeAction freezes or thaws a volume
+func (s *AdminServer) freezeAction(writer http.ResponseWriter, req *http.Request, name string, action string) {
+	freezer, ok := (*s.driver).(Freezer)
+	if !ok {
+		adminError(writer, req, http.StatusNotImplemented, fmt.Errorf("Driver does not support freeze"))
+		return
+	}
+	if action == "thaw" {
+		adminResult(writer, req, freezer.Thaw(name), nil)
+		return
+	}
+
+	param := req.URL.Query().Get("timeout")
+	if param == "" {
+		adminError(writer, req, http.StatusBadRequest, fmt.Errorf("Missing auto-thaw timeout"))
+		return
+	}
+	timeout, err := time.ParseDuration(param)
+	if err != nil {
+		adminError(writer, req, http.StatusBadRequest, fmt.Errorf("Invalid timeout %s: %v", param, err))
+		return
+	}
+	adminResult(writer, req, freezer.Freeze(name, timeout), nil)
+}
+
+// checkMethod writes an error unless req uses method
+func checkMethod(writer http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		adminError(writer, req, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", req.Method))
+		return false
+	}
+	return true
+}
+
+// adminResult writes result, or err as a conflict with the volume state
+func adminResult(writer http.ResponseWriter, req *http.Request, err error, result interface{}) {
+	if err != nil {
+		adminError(writer, req, http.StatusConflict, err)
+		return
+	}
+	if result == nil {
+		result = adminResponse{}
+	}
+	adminWrite(writer, req, http.StatusOK, result)
+}
+
+// adminError logs and writes an error message with status
+func adminError(writer http.ResponseWriter, req *http.Request, status int, err error) {
+	log.WithFields(log.Fields{"path": req.URL.Path, "status": status,
+		"err": err}).Error("Failed to service admin request ")
+	adminWrite(writer, req, status, adminResponse{Err: err.Error()})
+}
+
+// adminWrite writes the JSON encoding of resp with status
+func adminWrite(writer http.ResponseWriter, req *http.Request, status int, resp interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(resp); err != nil {
+		log.WithFields(log.Fields{"path": req.URL.Path, "err": err}).Error("Failed to service admin request ")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_server
+
+// A volume driver wrapper writing audit records of Docker volume requests,
+// see the audit package.
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/audit"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/reqid"
+)
+
+// FullNamer is implemented by drivers which qualify volume names, e.g.
+// with the datastore of the volume.
+type FullNamer interface {
+	// FullName returns the qualified name of an existing volume.
+	FullName(name string) string
+}
+
+// auditDriver forwards requests changing volume state to the wrapped
+// driver and writes their audit records while auditing is enabled
+type auditDriver struct {
+	volume.Driver
+	name      string // driver name recorded
+	requestID string // ID of the request served, see WithRequestID
+}
+
+// newAuditDriver wraps driver, recording driverName
+func newAuditDriver(driverName string, driver volume.Driver) volume.Driver {
+	return &auditDriver{Driver: driver, name: driverName}
+}
+
+// WithRequestID returns the audit driver recording the request with ID id
+func (d *auditDriver) WithRequestID(id string) volume.Driver {
+	return &auditDriver{Driver: reqid.WithID(d.Driver, id), name: d.name, requestID: id}
+}
+
+// fullName returns the qualified name of the volume name, or name if
+// the driver doesn't qualify names
+func (d *auditDriver) fullName(name string) string {
+	if namer, ok := d.Driver.(FullNamer); ok {
+		return namer.FullName(name)
+	}
+	return name
+}
+
+// record writes the audit record of a request, which got resp. The full
+// volume name is looked up only if the volume exists, fullName is set
+// for removed volumes. Containers using the volume are looked up for
+// mounts and unmounts, Docker answers within a short timeout or the
+// record lists no containers.
+func (d *auditDriver) record(op string, name string, fullName string, mountID string,
+	opts map[string]string, start time.Time, resp volume.Response) {
+	rec := audit.Record{
+		Time:       start.Format(time.RFC3339Nano),
+		RequestID:  d.requestID,
+		Driver:     d.name,
+		Operation:  op,
+		Volume:     name,
+		Options:    opts,
+		MountID:    mountID,
+		Result:     metrics.ResultSuccess,
+		DurationMs: int64(time.Since(start) / time.Millisecond),
+	}
+	if resp.Err != "" {
+		rec.Result = metrics.ResultError
+		rec.Error = resp.Err
+	}
+	if fullName != "" {
+		rec.Volume = fullName
+	} else if resp.Err == "" {
+		rec.Volume = d.fullName(name)
+	}
+	if op == "mount" || op == "unmount" {
+		containers, err := audit.VolumeContainers(name)
+		if err != nil {
+			reqid.Log(d.requestID).WithFields(log.Fields{"name": name, "err": err}).Warning(
+				"Failed to get containers of volume for audit ")
+			containers = []string{}
+		}
+		rec.Containers = containers
+	}
+	audit.Write(rec)
+}
+
+// Create - create a volume
+func (d *auditDriver) Create(r volume.Request) volume.Response {
+	if !audit.Enabled() {
+		return d.Driver.Create(r)
+	}
+	start := time.Now()
+	resp := d.Driver.Create(r)
+	d.record("create", r.Name, "", "", r.Options, start, resp)
+	return resp
+}
+
+// Remove - remove a volume
+func (d *auditDriver) Remove(r volume.Request) volume.Response {
+	if !audit.Enabled() {
+		return d.Driver.Remove(r)
+	}
+	start := time.Now()
+	fullName := d.fullName(r.Name)
+	resp := d.Driver.Remove(r)
+	d.record("remove", r.Name, fullName, "", nil, start, resp)
+	return resp
+}
+
+// Mount - mount a volume
+func (d *auditDriver) Mount(r volume.MountRequest) volume.Response {
+	if !audit.Enabled() {
+		return d.Driver.Mount(r)
+	}
+	start := time.Now()
+	resp := d.Driver.Mount(r)
+	d.record("mount", r.Name, "", r.ID, nil, start, resp)
+	return resp
+}
+
+// Unmount - unmount a volume
+func (d *auditDriver) Unmount(r volume.UnmountRequest) volume.Response {
+	if !audit.Enabled() {
+		return d.Driver.Unmount(r)
+	}
+	start := time.Now()
+	resp := d.Driver.Unmount(r)
+	d.record("unmount", r.Name, "", r.ID, nil, start, resp)
+	return resp
+}
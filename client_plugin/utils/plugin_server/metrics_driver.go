@@ -0,0 +1,78 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_server
+
+// A volume driver wrapper counting Docker volume requests and their outcomes.
+
+import (
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
+)
+
+// metricsDriver forwards requests to the wrapped driver and records
+// the result and latency of requests changing volume state
+type metricsDriver struct {
+	volume.Driver
+	name string // driver name used as metrics label
+}
+
+// newMetricsDriver wraps driver, labeling its metrics with driverName
+func newMetricsDriver(driverName string, driver volume.Driver) volume.Driver {
+	return &metricsDriver{Driver: driver, name: driverName}
+}
+
+// observe records a request of op started at start which got resp
+func (d *metricsDriver) observe(op string, start time.Time, resp volume.Response) {
+	result := metrics.ResultSuccess
+	if resp.Err != "" {
+		result = metrics.ResultError
+	}
+	metrics.VolumeOps.Inc(d.name, op, result)
+	metrics.VolumeOpDuration.Observe(time.Since(start).Seconds(), d.name, op)
+}
+
+// Create - create a volume
+func (d *metricsDriver) Create(r volume.Request) volume.Response {
+	start := time.Now()
+	resp := d.Driver.Create(r)
+	d.observe("create", start, resp)
+	return resp
+}
+
+// Remove - remove a volume
+func (d *metricsDriver) Remove(r volume.Request) volume.Response {
+	start := time.Now()
+	resp := d.Driver.Remove(r)
+	d.observe("remove", start, resp)
+	return resp
+}
+
+// Mount - mount a volume
+func (d *metricsDriver) Mount(r volume.MountRequest) volume.Response {
+	start := time.Now()
+	resp := d.Driver.Mount(r)
+	d.observe("mount", start, resp)
+	return resp
+}
+
+// Unmount - unmount a volume
+func (d *metricsDriver) Unmount(r volume.UnmountRequest) volume.Response {
+	start := time.Now()
+	resp := d.Driver.Unmount(r)
+	d.observe("unmount", start, resp)
+	return resp
+}
@@ -15,11 +15,17 @@
 package plugin_server
 
 import (
-	log "github.com/Sirupsen/logrus"
-	"github.com/docker/go-plugins-helpers/volume"
+	"encoding/json"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/audit"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/metrics"
 )
 
 const (
@@ -30,6 +36,9 @@ const (
 	// Docker volume plugin endpoints.
 	// Also see https://docs.docker.com/engine/extend/plugins_volume/#volume-plugin-protocol
 	volumeDriverCreatePath = "/VolumeDriver.Create"
+
+	// Plugin health endpoint, not a part of Docker plugin protocol.
+	pluginStatusPath = "/Plugin.Status"
 )
 
 // PluginServer responds to HTTP requests from Docker.
@@ -40,18 +49,125 @@ type PluginServer interface {
 	Destroy()
 }
 
-// StartServer starts a plugin server based on runtime OS
-func StartServer(driverName string, driver *volume.Driver) {
+// wrapDriver returns driver wrapped to record metrics, tag requests with
+// IDs and write audit records
+func wrapDriver(driverName string, driver volume.Driver) volume.Driver {
+	return newMetricsDriver(driverName, newRequestDriver(newAuditDriver(driverName, driver)))
+}
+
+// StatusReporter is implemented by drivers which can report plugin health.
+type StatusReporter interface {
+	Status() map[string]interface{}
+}
+
+// statusHandler returns a handler serving driver status as JSON,
+// or nil if the driver does not report status.
+func statusHandler(driver *volume.Driver) http.HandlerFunc {
+	reporter, ok := (*driver).(StatusReporter)
+	if !ok {
+		return nil
+	}
+	return func(writer http.ResponseWriter, req *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(writer).Encode(reporter.Status())
+		if err != nil {
+			log.WithFields(log.Fields{"path": pluginStatusPath, "err": err}).Error("Failed to service request ")
+		}
+	}
+}
+
+// Reloader is implemented by drivers which apply configuration changes
+// while the plugin runs.
+type Reloader interface {
+	// Reload applies the runtime fields of cfg, see config.Reload.
+	Reload(cfg config.Config)
+}
+
+// StartServer starts a plugin server based on runtime OS, and the admin
+// server, metrics and audit records as set in cfg. SIGHUP reloads the
+// configuration.
+func StartServer(driverName string, driver *volume.Driver, cfg config.Config) {
+	audit.Init(cfg.AuditLogPath, cfg.AuditMaxSizeMb, cfg.AuditMaxAgeDays)
+	startMetrics(cfg.MetricsAddress)
+	admin := startAdmin(cfg.AdminSock, driver)
 	server := NewPluginServer(driverName, driver)
 
 	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
-		sig := <-sigChannel
-		log.WithFields(log.Fields{"signal": sig}).Warning("Received signal ")
-		server.Destroy()
-		os.Exit(0)
+		for sig := range sigChannel {
+			if sig == syscall.SIGHUP {
+				log.WithFields(log.Fields{"signal": sig}).Info("Received signal, reloading configuration ")
+				cfg, admin = reload(cfg, driver, admin)
+				continue
+			}
+			log.WithFields(log.Fields{"signal": sig}).Warning("Received signal ")
+			server.Destroy()
+			if admin != nil {
+				admin.Destroy()
+			}
+			os.Exit(0)
+		}
 	}()
 
 	server.Init()
 }
+
+// startMetrics serves metrics on address unless it is empty
+func startMetrics(address string) {
+	if address == "" {
+		return
+	}
+	if err := metrics.Serve(address); err != nil {
+		log.WithFields(log.Fields{"address": address, "err": err}).Error("Failed to serve metrics ")
+	}
+}
+
+// startAdmin starts the admin server on adminSock, it returns nil if
+// adminSock is empty or the server fails to start
+func startAdmin(adminSock string, driver *volume.Driver) *AdminServer {
+	if adminSock == "" {
+		return nil
+	}
+	admin := NewAdminServer(adminSock, driver)
+	if err := admin.Init(); err != nil {
+		log.WithFields(log.Fields{"address": adminSock, "err": err}).Error("Failed to start admin server ")
+		return nil
+	}
+	return admin
+}
+
+// reload applies the runtime fields of the reloaded configuration and
+// returns it with the admin server, or cfg if the configuration can't be
+// reloaded
+func reload(cfg config.Config, driver *volume.Driver, admin *AdminServer) (config.Config, *AdminServer) {
+	next, err := config.Reload(cfg)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Failed to reload configuration ")
+		return cfg, admin
+	}
+
+	if err = config.SetupLog(next); err != nil {
+		log.WithFields(log.Fields{"err": err}).Error("Failed to reload log settings ")
+	}
+	if next.AuditLogPath != cfg.AuditLogPath || next.AuditMaxSizeMb != cfg.AuditMaxSizeMb ||
+		next.AuditMaxAgeDays != cfg.AuditMaxAgeDays {
+		audit.Init(next.AuditLogPath, next.AuditMaxSizeMb, next.AuditMaxAgeDays)
+	}
+	if next.MetricsAddress != cfg.MetricsAddress {
+		metrics.Stop()
+		startMetrics(next.MetricsAddress)
+	}
+	if next.AdminSock != cfg.AdminSock {
+		if admin != nil {
+			admin.Destroy()
+		}
+		admin = startAdmin(next.AdminSock, driver)
+	}
+	if reloader, ok := (*driver).(Reloader); ok {
+		reloader.Reload(next)
+	}
+
+	log.Info("Configuration reloaded ")
+	return next, admin
+}
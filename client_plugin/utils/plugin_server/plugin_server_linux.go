@@ -18,8 +18,10 @@ package plugin_server
 // relies on the docker/go-plugins-helpers/volume API.
 
 import (
+	"net"
 	"os"
 	"path/filepath"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/go-plugins-helpers/volume"
@@ -33,8 +35,9 @@ const (
 // SockPluginServer serves HTTP requests from Docker over unix sock.
 type SockPluginServer struct {
 	PluginServer
-	sockAddr string         // Server's unix sock address
-	driver   *volume.Driver // The driver implementation
+	sockAddr   string         // Server's unix sock address
+	driverName string         // The driver name
+	driver     *volume.Driver // The driver implementation
 }
 
 // An equivalent function is not exported from the SDK.
@@ -49,13 +52,16 @@ func fullSocketAddress(pluginName string) string {
 
 // NewPluginServer creates a new instance of SockPluginServer.
 func NewPluginServer(driverName string, driver *volume.Driver) *SockPluginServer {
-	return &SockPluginServer{sockAddr: fullSocketAddress(driverName), driver: driver}
+	return &SockPluginServer{sockAddr: fullSocketAddress(driverName), driverName: driverName, driver: driver}
 }
 
 // Init registers the volume driver with a handler to service HTTP
 // requests from Docker.
 func (s *SockPluginServer) Init() {
-	handler := volume.NewHandler(*s.driver)
+	handler := volume.NewHandler(wrapDriver(s.driverName, *s.driver))
+	if status := statusHandler(s.driver); status != nil {
+		handler.HandleFunc(pluginStatusPath, status)
+	}
 
 	log.WithFields(log.Fields{
 		"address": s.sockAddr,
@@ -68,3 +74,12 @@ func (s *SockPluginServer) Init() {
 func (s *SockPluginServer) Destroy() {
 	os.Remove(s.sockAddr)
 }
+
+// listenUnix listens on the unix sock sockAddr, created with permissions
+// perm. The umask is set while the sock is created, so it is never open
+// to others, files created meanwhile get no more than perm either.
+func listenUnix(sockAddr string, perm os.FileMode) (net.Listener, error) {
+	mask := syscall.Umask(int(0777 &^ perm))
+	defer syscall.Umask(mask)
+	return net.Listen("unix", sockAddr)
+}
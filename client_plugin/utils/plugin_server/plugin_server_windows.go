@@ -40,6 +40,7 @@ const (
 type NpipePluginServer struct {
 	PluginServer
 	driver   *volume.Driver // The driver implementation
+	served   volume.Driver  // The driver serving requests, see wrapDriver
 	mux      *http.ServeMux // The HTTP mux
 	listener net.Listener   // The npipe listener
 }
@@ -51,7 +52,8 @@ type PluginActivateResponse struct {
 
 // NewPluginServer returns a new instance of NpipePluginServer.
 func NewPluginServer(driverName string, driver *volume.Driver) *NpipePluginServer {
-	return &NpipePluginServer{driver: driver, mux: http.NewServeMux()}
+	return &NpipePluginServer{driver: driver, served: wrapDriver(driverName, *driver),
+		mux: http.NewServeMux()}
 }
 
 // writeJSON writes the JSON encoding of resp to the writer.
@@ -90,7 +92,7 @@ func (s *NpipePluginServer) VolumeDriverCreate(writer http.ResponseWriter, req *
 		return
 	}
 
-	resp := (*s.driver).Create(volumeReq)
+	resp := s.served.Create(volumeReq)
 	errJSON := writeJSON(resp, &writer)
 	if errJSON != nil {
 		writeError(volumeDriverCreatePath, writer, req, http.StatusInternalServerError, errJSON)
@@ -103,6 +105,9 @@ func (s *NpipePluginServer) VolumeDriverCreate(writer http.ResponseWriter, req *
 func (s *NpipePluginServer) registerHandlers() {
 	s.mux.HandleFunc(pluginActivatePath, s.PluginActivate)
 	s.mux.HandleFunc(volumeDriverCreatePath, s.VolumeDriverCreate)
+	if status := statusHandler(s.driver); status != nil {
+		s.mux.HandleFunc(pluginStatusPath, status)
+	}
 }
 
 // Init initializes the npipe listener which serves HTTP requests
@@ -127,3 +132,9 @@ func (s *NpipePluginServer) Destroy() {
 	log.WithFields(log.Fields{"npipe": npipeAddr}).Info("Closing npipe listener ")
 	s.listener.Close()
 }
+
+// listenUnix listens on the unix sock sockAddr. Permissions are not
+// applied on Windows, where the admin interface is off by default.
+func listenUnix(sockAddr string, perm os.FileMode) (net.Listener, error) {
+	return net.Listen("unix", sockAddr)
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_server
+
+// A volume driver wrapper giving Docker volume requests an ID, see the
+// reqid package.
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/reqid"
+)
+
+// requestDriver forwards requests changing volume state to the wrapped
+// driver under a new request ID, and returns the ID in errors so users
+// can quote it
+type requestDriver struct {
+	volume.Driver
+}
+
+// newRequestDriver wraps driver
+func newRequestDriver(driver volume.Driver) volume.Driver {
+	return &requestDriver{Driver: driver}
+}
+
+// start gives the request a new ID, logs it and returns the wrapped
+// driver serving the request
+func (d *requestDriver) start(op string, name string) (string, volume.Driver) {
+	id := reqid.New()
+	log.WithFields(log.Fields{reqid.Key: id, "op": op, "name": name}).Debug("Serving request ")
+	return id, reqid.WithID(d.Driver, id)
+}
+
+// end logs a failed request and tags the error in resp with the request ID
+func end(id string, op string, name string, resp *volume.Response) {
+	if resp.Err == "" {
+		return
+	}
+	log.WithFields(log.Fields{reqid.Key: id, "op": op, "name": name, "err": resp.Err}).Error("Request failed ")
+	resp.Err = fmt.Sprintf("%s (request ID %s)", resp.Err, id)
+}
+
+// Create - create a volume
+func (d *requestDriver) Create(r volume.Request) (resp volume.Response) {
+	id, driver := d.start("create", r.Name)
+	defer end(id, "create", r.Name, &resp)
+	return driver.Create(r)
+}
+
+// Remove - remove a volume
+func (d *requestDriver) Remove(r volume.Request) (resp volume.Response) {
+	id, driver := d.start("remove", r.Name)
+	defer end(id, "remove", r.Name, &resp)
+	return driver.Remove(r)
+}
+
+// Mount - mount a volume
+func (d *requestDriver) Mount(r volume.MountRequest) (resp volume.Response) {
+	id, driver := d.start("mount", r.Name)
+	defer end(id, "mount", r.Name, &resp)
+	return driver.Mount(r)
+}
+
+// Unmount - unmount a volume
+func (d *requestDriver) Unmount(r volume.UnmountRequest) (resp volume.Response) {
+	id, driver := d.start("unmount", r.Name)
+	defer end(id, "unmount", r.Name, &resp)
+	return driver.Unmount(r)
+}
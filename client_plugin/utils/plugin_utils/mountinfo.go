@@ -0,0 +1,324 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_utils
+
+// This file holds helpers to find mounts owned by the plugin using
+// /proc/self/mountinfo, see proc(5) for the file format.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// mount table of the plugin's own mount namespace
+	linuxMountInfoFile = "/proc/self/mountinfo"
+
+	// managed plugins get mounts propagated to the host under
+	// <docker root>/plugins/<plugin id>/rootfs/<PropagatedMount>
+	managedPluginRootfs = "/rootfs"
+)
+
+// MountInfo - a single line of /proc/self/mountinfo. Paths are unescaped.
+type MountInfo struct {
+	MountID      int      // unique ID of the mount
+	ParentID     int      // ID of the parent mount
+	Major        uint32   // major of st_dev of files on this mount
+	Minor        uint32   // minor of st_dev of files on this mount
+	Root         string   // root of the mount within the filesystem (not "/" for bind mounts)
+	MountPoint   string   // mount point relative to the process root
+	Options      string   // per mount options
+	Optional     []string // optional fields, e.g. shared:N or master:N
+	FsType       string   // filesystem type
+	Source       string   // filesystem specific source, e.g. the device
+	SuperOptions string   // per superblock options
+}
+
+// ParseMountInfo - parse mountinfo formatted data
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var entries []MountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// parseMountInfoLine parses a line in format:
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (MountInfo, error) {
+	var entry MountInfo
+
+	fields := strings.Fields(line)
+	// optional fields are terminated by a single hyphen
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if len(fields) < 10 || sep < 0 || len(fields) < sep+4 {
+		return entry, fmt.Errorf("Invalid mountinfo line: %s", line)
+	}
+
+	var err error
+	if entry.MountID, err = strconv.Atoi(fields[0]); err != nil {
+		return entry, fmt.Errorf("Invalid mount ID in mountinfo line: %s", line)
+	}
+	if entry.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+		return entry, fmt.Errorf("Invalid parent ID in mountinfo line: %s", line)
+	}
+	dev := strings.Split(fields[2], ":")
+	if len(dev) != 2 {
+		return entry, fmt.Errorf("Invalid major:minor in mountinfo line: %s", line)
+	}
+	major, errMajor := strconv.ParseUint(dev[0], 10, 32)
+	minor, errMinor := strconv.ParseUint(dev[1], 10, 32)
+	if errMajor != nil || errMinor != nil {
+		return entry, fmt.Errorf("Invalid major:minor in mountinfo line: %s", line)
+	}
+	entry.Major = uint32(major)
+	entry.Minor = uint32(minor)
+	entry.Root = unescapeMountPath(fields[3])
+	entry.MountPoint = unescapeMountPath(fields[4])
+	entry.Options = fields[5]
+	entry.Optional = fields[6:sep]
+	entry.FsType = unescapeMountPath(fields[sep+1])
+	entry.Source = unescapeMountPath(fields[sep+2])
+	entry.SuperOptions = fields[sep+3]
+	return entry, nil
+}
+
+// unescapeMountPath - the kernel escapes space, tab, newline and backslash
+// in mountinfo as 3 digit octal, e.g. "\040" for a space
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+	buf := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) && isOctal(path[i+1:i+4]) {
+			c, _ := strconv.ParseUint(path[i+1:i+4], 8, 8)
+			buf = append(buf, byte(c))
+			i += 3
+			continue
+		}
+		buf = append(buf, path[i])
+	}
+	return string(buf)
+}
+
+// isOctal - check if s is a 3 digit octal number
+func isOctal(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '7' {
+			return false
+		}
+	}
+	return true
+}
+
+// optionalValue returns value of the optional field tag:N, or 0 if not present
+func (m MountInfo) optionalValue(tag string) int {
+	for _, field := range m.Optional {
+		if strings.HasPrefix(field, tag+":") {
+			value, err := strconv.Atoi(strings.TrimPrefix(field, tag+":"))
+			if err == nil {
+				return value
+			}
+		}
+	}
+	return 0
+}
+
+// SharedPeerGroup - peer group of a shared mount, 0 if the mount isn't shared
+func (m MountInfo) SharedPeerGroup() int {
+	return m.optionalValue("shared")
+}
+
+// MasterPeerGroup - peer group a slave mount receives propagation from,
+// 0 if the mount isn't a slave
+func (m MountInfo) MasterPeerGroup() int {
+	return m.optionalValue("master")
+}
+
+// IsBindMount - check if the mount exposes a sub tree of the filesystem
+func (m MountInfo) IsBindMount() bool {
+	return m.Root != "/"
+}
+
+// SameDevice - check if both mounts are of the same filesystem
+func (m MountInfo) SameDevice(other MountInfo) bool {
+	return m.Major == other.Major && m.Minor == other.Minor
+}
+
+// GetMountInfoEntries - return entries of the plugin's mount table
+func GetMountInfoEntries() ([]MountInfo, error) {
+	file, err := os.Open(linuxMountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseMountInfo(file)
+}
+
+// GetPluginMounts - return a map of volume name -> mount for volumes
+// mounted by the plugin, i.e. whole filesystems mounted at <mountRoot>/<volume>
+func GetPluginMounts(entries []MountInfo, mountRoot string) map[string]MountInfo {
+	mounts := make(map[string]MountInfo)
+	root := filepath.Clean(mountRoot)
+	for _, entry := range entries {
+		// bind mounts of a sub tree are never created by the plugin
+		if entry.IsBindMount() {
+			continue
+		}
+		if filepath.Dir(filepath.Clean(entry.MountPoint)) != root {
+			continue
+		}
+		// stacked mounts - the last one is visible
+		mounts[filepath.Base(entry.MountPoint)] = entry
+	}
+	return mounts
+}
+
+// GetDeviceMounts - return all mounts of the filesystem mounted by mount,
+// including bind mounts. Copies of mount propagated to peers and slaves of
+// its parent, e.g. a shared or slave bind mount of the mount root, are left
+// out, they are unmounted with mount.
+func GetDeviceMounts(entries []MountInfo, mount MountInfo) []MountInfo {
+	var mounts []MountInfo
+	for _, entry := range entries {
+		if entry.SameDevice(mount) && !isPropagatedCopy(entries, mount, entry) {
+			mounts = append(mounts, entry)
+		}
+	}
+	return mounts
+}
+
+// isPropagatedCopy - check if entry is a copy of mount in a peer or slave
+// of the parent of mount, at the same place in the parent filesystem
+func isPropagatedCopy(entries []MountInfo, mount MountInfo, entry MountInfo) bool {
+	if entry.MountID == mount.MountID || !receivesFrom(entry, mount.SharedPeerGroup()) {
+		return false
+	}
+	parent, found := findMount(entries, mount.ParentID)
+	if !found {
+		return false
+	}
+	entryParent, found := findMount(entries, entry.ParentID)
+	if !found || !receivesFrom(entryParent, parent.SharedPeerGroup()) {
+		return false
+	}
+	return placeInParent(parent, mount) == placeInParent(entryParent, entry)
+}
+
+// receivesFrom - check if m is in the shared peer group, or a slave of it
+func receivesFrom(m MountInfo, group int) bool {
+	return group != 0 && (m.SharedPeerGroup() == group || m.MasterPeerGroup() == group)
+}
+
+// findMount - return the entry with the mount ID
+func findMount(entries []MountInfo, mountID int) (MountInfo, bool) {
+	for _, entry := range entries {
+		if entry.MountID == mountID {
+			return entry, true
+		}
+	}
+	return MountInfo{}, false
+}
+
+// placeInParent - path of the mount point of m within the filesystem of
+// its parent mount
+func placeInParent(parent MountInfo, m MountInfo) string {
+	rel, err := filepath.Rel(parent.MountPoint, m.MountPoint)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(parent.Root, rel)
+}
+
+// pluginDriverNames - names Docker may report as a mount driver for a plugin driver
+var pluginDriverNames = map[string][]string{
+	"vsphere": {"vsphere", "vmdk", "docker-volume-vsphere"},
+	"photon":  {"photon"},
+	"shared":  {"shared", "vsphere-shared"},
+}
+
+// IsPluginDriver - check if driver reported by Docker for a mount is served
+// by the plugin with driverName. Managed plugins are reported by plugin
+// reference, e.g. "vmware/docker-volume-vsphere:latest"
+func IsPluginDriver(driver string, driverName string) bool {
+	name := driver
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+
+	names, exists := pluginDriverNames[driverName]
+	if !exists {
+		return name == driverName
+	}
+	for _, n := range names {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPluginMount - check if a volume mount reported by Docker belongs to the
+// plugin. The mount belongs to the plugin if Docker reports our driver, or if
+// its source is one of the mounts in pluginMounts (see GetPluginMounts), either
+// directly (plugin running as a service) or as propagated by Docker from a
+// managed plugin rootfs
+func IsPluginMount(driver string, source string, driverName string, pluginMounts map[string]MountInfo) bool {
+	if driver != "" && IsPluginDriver(driver, driverName) {
+		return true
+	}
+
+	source = filepath.Clean(source)
+	for _, mount := range pluginMounts {
+		mountPoint := filepath.Clean(mount.MountPoint)
+		if source == mountPoint {
+			return true
+		}
+		if strings.HasSuffix(source, managedPluginRootfs+mountPoint) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin_utils_test
+
+// Test ownership of mounts for legacy (service) and managed plugin layouts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// plugin running as a service, in the host mount namespace
+const legacyMountInfo = `
+17 22 0:16 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+22 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+120 22 8:16 / /mnt/vmdk/vol1@datastore1 rw,relatime shared:80 - ext4 /dev/sdb rw,data=ordered
+121 22 8:32 / /mnt/vmdk/vol2@vsanDatastore rw,relatime shared:81 - xfs /dev/sdc rw
+122 22 8:16 /data /srv/bind rw,relatime shared:80 - ext4 /dev/sdb rw,data=ordered
+`
+
+// managed plugin, in its own mount namespace with /mnt/vmdk propagated to the host
+const managedMountInfo = `
+301 280 0:52 / / rw,relatime - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/X
+302 301 0:54 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+310 301 0:21 /docker/plugins/5ed8/propagated-mount /mnt/vmdk rw,relatime shared:120 - tmpfs tmpfs rw
+311 310 8:48 / /mnt/vmdk/vol3@datastore1 rw,relatime shared:121 - ext4 /dev/sdd rw,data=ordered
+`
+
+const managedSource = "/var/lib/docker/plugins/5ed8ae1e2d4c/rootfs/mnt/vmdk/vol3@datastore1"
+
+func parse(t *testing.T, data string) []plugin_utils.MountInfo {
+	entries, err := plugin_utils.ParseMountInfo(strings.NewReader(data))
+	assert.Nil(t, err)
+	return entries
+}
+
+func TestParseMountInfo(t *testing.T) {
+	entries := parse(t, legacyMountInfo)
+	if assert.Equal(t, 5, len(entries)) {
+		e := entries[2]
+		assert.Equal(t, 120, e.MountID)
+		assert.Equal(t, 22, e.ParentID)
+		assert.Equal(t, uint32(8), e.Major)
+		assert.Equal(t, uint32(16), e.Minor)
+		assert.Equal(t, "/", e.Root)
+		assert.Equal(t, "/mnt/vmdk/vol1@datastore1", e.MountPoint)
+		assert.Equal(t, []string{"shared:80"}, e.Optional)
+		assert.Equal(t, "ext4", e.FsType)
+		assert.Equal(t, "/dev/sdb", e.Source)
+		assert.Equal(t, "rw,data=ordered", e.SuperOptions)
+	}
+
+	// no optional fields
+	assert.Equal(t, 0, len(parse(t, managedMountInfo)[0].Optional))
+
+	_, err := plugin_utils.ParseMountInfo(strings.NewReader("22 0 8 / / rw - ext4 /dev/sda1 rw\n"))
+	assert.NotNil(t, err, "Line with invalid major:minor should fail")
+
+	_, err = plugin_utils.ParseMountInfo(strings.NewReader("22 0 8:1 / / rw shared:1 ext4 /dev/sda1 rw\n"))
+	assert.NotNil(t, err, "Line without separator should fail")
+}
+
+func TestGetPluginMountsLegacy(t *testing.T) {
+	mounts := plugin_utils.GetPluginMounts(parse(t, legacyMountInfo), "/mnt/vmdk")
+	assert.Equal(t, 2, len(mounts))
+	assert.Equal(t, "/dev/sdb", mounts["vol1@datastore1"].Source)
+	assert.Equal(t, "/dev/sdc", mounts["vol2@vsanDatastore"].Source)
+
+	// bind mount of the same device is not a plugin mount
+	assert.False(t, plugin_utils.IsPluginMount("", "/srv/bind", "vsphere", mounts))
+	assert.True(t, plugin_utils.IsPluginMount("", "/mnt/vmdk/vol1@datastore1", "vsphere", mounts))
+	assert.False(t, plugin_utils.IsPluginMount("local", "/var/lib/docker/volumes/x/_data", "vsphere", mounts))
+}
+
+func TestGetPluginMountsManaged(t *testing.T) {
+	mounts := plugin_utils.GetPluginMounts(parse(t, managedMountInfo), "/mnt/vmdk")
+	assert.Equal(t, 1, len(mounts))
+	assert.Equal(t, "/dev/sdd", mounts["vol3@datastore1"].Source)
+
+	// propagated mount source, driver reported under an unknown alias
+	assert.True(t, plugin_utils.IsPluginMount("myalias:latest", managedSource, "vsphere", mounts))
+	assert.False(t, plugin_utils.IsPluginMount("myalias:latest",
+		"/var/lib/docker/plugins/5ed8ae1e2d4c/rootfs/mnt/vmdk/other", "vsphere", mounts))
+}
+
+func TestGetPluginMountsCustomRoot(t *testing.T) {
+	data := "130 22 8:64 / /data/volumes/vol4 rw - ext4 /dev/sde rw\n"
+	mounts := plugin_utils.GetPluginMounts(parse(t, data), "/data/volumes/")
+	assert.Equal(t, "/dev/sde", mounts["vol4"].Source)
+	assert.Equal(t, 0, len(plugin_utils.GetPluginMounts(parse(t, data), "/data")))
+}
+
+func TestIsPluginDriver(t *testing.T) {
+	assert.True(t, plugin_utils.IsPluginDriver("vsphere", "vsphere"))
+	assert.True(t, plugin_utils.IsPluginDriver("vmdk", "vsphere"))
+	assert.True(t, plugin_utils.IsPluginDriver("vsphere:latest", "vsphere"))
+	assert.True(t, plugin_utils.IsPluginDriver("vmware/docker-volume-vsphere:latest", "vsphere"))
+	assert.True(t, plugin_utils.IsPluginDriver("registry:5000/vmware/vsphere-shared:0.13", "shared"))
+	assert.True(t, plugin_utils.IsPluginDriver("photon", "photon"))
+	assert.False(t, plugin_utils.IsPluginDriver("local", "vsphere"))
+	assert.False(t, plugin_utils.IsPluginDriver("vmware/docker-volume-vsphere:latest", "shared"))
+}
+
+func TestParseMountInfoEscaped(t *testing.T) {
+	data := `140 22 8:80 / /mnt/vmdk/my\040vol@datastore\0401 rw - ext4 /dev/disk\134by\011x rw
+141 22 8:80 / /mnt/vmdk/trailing\ rw - ext4 /dev/sdf rw
+`
+	entries := parse(t, data)
+	assert.Equal(t, "/mnt/vmdk/my vol@datastore 1", entries[0].MountPoint)
+	assert.Equal(t, "/dev/disk\\by\tx", entries[0].Source)
+	assert.Equal(t, "/mnt/vmdk/trailing\\", entries[1].MountPoint)
+
+	mounts := plugin_utils.GetPluginMounts(entries, "/mnt/vmdk")
+	_, exists := mounts["my vol@datastore 1"]
+	assert.True(t, exists)
+}
+
+func TestMountPropagation(t *testing.T) {
+	data := `150 22 8:96 / /mnt/vmdk/vol5 rw shared:90 master:12 propagate_from:3 - ext4 /dev/sdg rw
+151 22 8:96 / /mnt/vmdk/vol6 rw unbindable - ext4 /dev/sdg rw
+`
+	entries := parse(t, data)
+	assert.Equal(t, 90, entries[0].SharedPeerGroup())
+	assert.Equal(t, 12, entries[0].MasterPeerGroup())
+	assert.Equal(t, 0, entries[1].SharedPeerGroup())
+	assert.Equal(t, 0, entries[1].MasterPeerGroup())
+}
+
+func TestGetDeviceMounts(t *testing.T) {
+	entries := parse(t, legacyMountInfo)
+	mounts := plugin_utils.GetPluginMounts(entries, "/mnt/vmdk")
+
+	// vol1 is also bind mounted at /srv/bind
+	devMounts := plugin_utils.GetDeviceMounts(entries, mounts["vol1@datastore1"])
+	if assert.Equal(t, 2, len(devMounts)) {
+		assert.False(t, devMounts[0].IsBindMount())
+		assert.Equal(t, "/srv/bind", devMounts[1].MountPoint)
+		assert.True(t, devMounts[1].IsBindMount())
+	}
+	assert.Equal(t, 1, len(plugin_utils.GetDeviceMounts(entries, mounts["vol2@vsanDatastore"])))
+}
+
+// /mnt/vmdk bind mounted as a peer and as a slave, volumes mounted in it
+// are propagated to both
+const propagatedMountInfo = `
+22 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+100 22 0:40 / /mnt/vmdk rw,relatime shared:50 - tmpfs tmpfs rw
+101 22 0:40 / /srv/vmdk-peer rw,relatime shared:50 - tmpfs tmpfs rw
+102 22 0:40 / /srv/vmdk-slave rw,relatime master:50 - tmpfs tmpfs rw
+120 100 8:16 / /mnt/vmdk/vol1@datastore1 rw,relatime shared:80 - ext4 /dev/sdb rw
+121 101 8:16 / /srv/vmdk-peer/vol1@datastore1 rw,relatime shared:80 - ext4 /dev/sdb rw
+122 102 8:16 / /srv/vmdk-slave/vol1@datastore1 rw,relatime master:80 - ext4 /dev/sdb rw
+123 22 8:16 /data /srv/bind rw,relatime shared:80 - ext4 /dev/sdb rw
+124 102 8:32 / /srv/vmdk-slave/vol2@datastore1 rw,relatime master:81 - ext4 /dev/sdc rw
+125 100 8:32 / /mnt/vmdk/vol2@datastore1 rw,relatime - ext4 /dev/sdc rw
+`
+
+func TestGetDeviceMountsPropagated(t *testing.T) {
+	entries := parse(t, propagatedMountInfo)
+	mounts := plugin_utils.GetPluginMounts(entries, "/mnt/vmdk")
+
+	// copies in the peer and the slave go away with the mount, the bind
+	// mount of a sub tree doesn't
+	devMounts := plugin_utils.GetDeviceMounts(entries, mounts["vol1@datastore1"])
+	if assert.Equal(t, 2, len(devMounts)) {
+		assert.Equal(t, "/mnt/vmdk/vol1@datastore1", devMounts[0].MountPoint)
+		assert.Equal(t, "/srv/bind", devMounts[1].MountPoint)
+	}
+
+	// a private mount propagates nothing, the other mount is independent
+	assert.Equal(t, 2, len(plugin_utils.GetDeviceMounts(entries, mounts["vol2@datastore1"])))
+}
@@ -17,8 +17,6 @@ package plugin_utils
 // This file holds utility/helper methods required in plugin module
 
 import (
-	"io/ioutil"
-	"path/filepath"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -26,15 +24,15 @@ import (
 )
 
 const (
-	// consts for finding and parsing linux mount information
-	linuxMountsFile = "/proc/mounts"
-
 	// index datastore from volume meta
 	// "datastore" key is defined in vmdkops service
 	datastoreKey = "datastore"
 
 	// PluginInitError message to indicate that plugin initialization(refcounting) is not yet complete
 	PluginInitError = "Plugin initialization in progress."
+
+	// PluginDegradedError message to indicate that refcounting failed repeatedly and is being retried
+	PluginDegradedError = "Plugin is running in degraded mode, refcount discovery keeps failing - please check docker."
 )
 
 // VolumeInfo - Volume fullname, datastore and metadata
@@ -46,40 +44,47 @@ type VolumeInfo struct {
 
 // GetMountInfo - return a map of mounted volumes and devices
 func GetMountInfo(mountRoot string) (map[string]string, error) {
-	volumeMountMap := make(map[string]string) //map [volume mount path] -> device
-	data, err := ioutil.ReadFile(linuxMountsFile)
-
+	volumeMountMap := make(map[string]string) //map [volume name] -> device
+	entries, err := GetMountInfoEntries()
 	if err != nil {
-		log.Errorf("Can't get info from %s (%v)", linuxMountsFile, err)
+		log.Errorf("Can't get info from %s (%v)", linuxMountInfoFile, err)
 		return volumeMountMap, err
 	}
 
-	for _, line := range strings.Split(string(data), "\n") {
-		field := strings.Fields(line)
-		if len(field) < 2 {
-			continue // skip empty line and lines too short to have our mount
-		}
-		// fields format: [/dev/sdb /mnt/vmdk/vol1 ext2 rw,relatime 0 0]
-		if filepath.Dir(field[1]) != mountRoot {
-			continue
-		}
-		volumeMountMap[filepath.Base(field[1])] = field[0]
+	for name, mount := range GetPluginMounts(entries, mountRoot) {
+		volumeMountMap[name] = mount.Source
 	}
 	return volumeMountMap, nil
 }
 
-// AlreadyMounted - check if volume is already mounted on the mountRoot
-func AlreadyMounted(name string, mountRoot string) bool {
-	volumeMap, err := GetMountInfo(mountRoot)
-
+// GetVolumeMounts - return mount of the volume on the mountRoot, or nil if
+// the volume isn't mounted, and other mount points of the same filesystem,
+// e.g. bind mounts, which keep the device busy after the volume is unmounted
+func GetVolumeMounts(name string, mountRoot string) (*MountInfo, []string, error) {
+	entries, err := GetMountInfoEntries()
 	if err != nil {
-		return false
+		log.Errorf("Can't get info from %s (%v)", linuxMountInfoFile, err)
+		return nil, nil, err
 	}
 
-	if _, ok := volumeMap[name]; ok {
-		return true
+	mount, mounted := GetPluginMounts(entries, mountRoot)[name]
+	if !mounted {
+		return nil, nil, nil
 	}
-	return false
+
+	var others []string
+	for _, entry := range GetDeviceMounts(entries, mount) {
+		if entry.MountID != mount.MountID {
+			others = append(others, entry.MountPoint)
+		}
+	}
+	return &mount, others, nil
+}
+
+// AlreadyMounted - check if volume is already mounted on the mountRoot
+func AlreadyMounted(name string, mountRoot string) bool {
+	mount, _, err := GetVolumeMounts(name, mountRoot)
+	return err == nil && mount != nil
 }
 
 // makeFullVolName - return a full name in format volume@datastore
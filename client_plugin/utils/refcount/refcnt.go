@@ -37,16 +37,21 @@
 // generic refcnt API and also supports refcount discovery on restarts:
 // - Connects to Docker over unix socket, enumerates Volume Mounts and builds
 //   "volume mounts refcount" map as Docker sees it.
-// - Gets actual mounts from /proc/mounts, and makes sure the refcounts and
+// - Gets actual mounts from /proc/self/mountinfo, and makes sure the refcounts and
 //   actual mounts are in sync.
 //
 // The process is initiated on plugin start,and ONLY if Docker is already
 // running and thus answering client.Info() request.
+// It can also be requested at any time with Reconcile, e.g. from the admin
+// interface after Docker state was fixed up manually.
 //
-// After refcount discovery, results are compared to /proc/mounts content.
+// After refcount discovery, results are compared to /proc/self/mountinfo content.
 //
 // We rely on all plugin mounts being in /mnt/vmdk/<volume_name>, and will
 // unount stuff there at will - this place SHOULD NOT be used for manual mounts.
+// A container mount is counted if Docker reports our driver for it, or if its
+// source is one of our mounts in /proc/self/mountinfo, either directly or as
+// propagated from a managed plugin rootfs (see plugin_utils.IsPluginMount).
 //
 // If a volume IS mounted, but should not be (refcount = 0)
 //   - we assume there was a restart of VM or even ESX, and
@@ -58,10 +63,22 @@
 //     active so the disk should not have been unmounted
 //   - we just log an error and keep going. Recovery in this case is manual
 //
-// We assume that mounted (in Docker VM) and attached (to Docker VM) is the
-// same. If something is attached to VM but not mounted (so from refcnt and
-// mountspoint of view the volume is not used, but the VMDK is still attached
-// to the VM) - we leave it to manual recovery.
+// If a volume is attached to the VM but NOT mounted and has no refcount
+// (e.g. plugin crashed between attach and mount), it is an orphan:
+//   - drivers able to list attached volumes (see drivers.AttachedVolumesLister)
+//     report them, and we detach orphans, or only log them in dry-run mode
+//   - for other drivers we leave it to manual recovery
+//
+// Plugin health is tracked as a small state machine:
+//   - initializing: refcount discovery has not completed yet
+//   - healthy:      refcounts are in sync with Docker, all operations allowed
+//   - degraded:     discovery kept failing for refCountRetryAttempts. Instead of
+//                   panicking (which only restarts the plugin into the same
+//                   failure), we keep retrying in the background at a fixed
+//                   interval and switch to healthy as soon as it succeeds.
+// While not healthy, mounts are served (discovery will recount them), unmounts
+// are deferred (recovery unmounts volumes Docker no longer uses) and removes
+// are refused since we can't tell if a volume is in use.
 //
 // The RefCountsMap is safe to be used by multiple goroutines and has a single
 // RWMutex to serialize operations on the map and refCounts.
@@ -72,8 +89,8 @@
 package refcount
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
@@ -87,28 +104,60 @@ import (
 )
 
 const (
-	ApiVersion              = "v1.24" // docker engine 1.12 and above support this api version
-	DockerUSocket           = "unix:///var/run/docker.sock"
-	defaultSleepIntervalSec = 1
-	dockerConnTimeoutSec    = 2
-	refCountDelayStartSec   = 2
-	refCountRetryAttempts   = 20
+	ApiVersion               = "v1.24" // docker engine 1.12 and above support this api version
+	DockerUSocket            = "unix:///var/run/docker.sock"
+	defaultSleepIntervalSec  = 1
+	dockerConnTimeoutSec     = 2
+	refCountDelayStartSec    = 2
+	refCountRetryAttempts    = 20
+	degradedRetryIntervalSec = 60 // retry interval once the plugin is degraded
+	inspectWorkers           = 8  // max parallel ContainerInspect calls
+	inspectRetryAttempts     = 3  // ContainerInspect attempts per container
 
 	photonDriver = "photon"
 )
 
+// PluginState - health of the plugin as seen by refcount discovery
+type PluginState int
+
+const (
+	// StateInitializing - refcount discovery is in progress
+	StateInitializing PluginState = iota
+	// StateHealthy - refcounts are discovered and in sync with Docker
+	StateHealthy
+	// StateDegraded - refcount discovery failed repeatedly, still retrying
+	StateDegraded
+)
+
+// String returns a printable name of the state
+func (s PluginState) String() string {
+	switch s {
+	case StateInitializing:
+		return "initializing"
+	case StateHealthy:
+		return "healthy"
+	case StateDegraded:
+		return "degraded"
+	}
+	return "unknown"
+}
+
 // info about individual volume ref counts and mount
 type refCount struct {
 	// refcount for the given volume.
 	count uint
 
 	// Is the volume mounted from OS point of view
-	// (i.e. entry in /proc/mounts exists)
+	// (i.e. entry in /proc/self/mountinfo exists)
 	mounted bool
 
 	// Volume is mounted from this device. Used on recovery only , for info
 	// purposes. Value is empty during normal operation
 	dev string
+
+	// Other mount points of the volume filesystem, e.g. bind mounts.
+	// Used on recovery only, the volume is kept if any are found
+	others []string
 }
 
 // RefCountsMap struct
@@ -116,12 +165,21 @@ type RefCountsMap struct {
 	refMap map[string]*refCount // Map of refCounts
 	mtx    *sync.RWMutex        // Synchronizes RefCountsMap ops
 
-	refcntInitSuccess bool        // save refcounting success
-	isDirty           bool        // flag to check reconciling has been interrupted
-	StateMtx          *sync.Mutex // (Exported) Synchronizes refcounting between mount/unmount and refcounting thread
+	state        PluginState // plugin health, see PluginState
+	stateSince   time.Time   // time of the last state change
+	attempts     int         // failed discovery attempts since start or last success
+	lastErr      error       // error of the last failed discovery attempt
+	isDirty      bool        // flag to check reconciling has been interrupted
+	orphanDryRun bool        // only log attached but unused volumes, don't detach
+	retrying     bool        // discovery is being retried in the background
+	calcMtx      *sync.Mutex // serializes discovery on start, retries and reconcile requests
+	StateMtx     *sync.Mutex // (Exported) Synchronizes refcounting between mount/unmount and refcounting thread
 }
 
 var (
+	// returned when mount/unmount happened during refcount discovery
+	errDirty = errors.New("refcounting wasn't clean.")
+
 	// vmdk or local. We use "vmdk" only in production, but need "local" to
 	// allow no-ESX test. sanity_test.go '-d' flag allows to switch it to local
 	driverName string
@@ -144,9 +202,11 @@ func NewRefCountsMap() *RefCountsMap {
 		refMap: make(map[string]*refCount),
 		mtx:    &sync.RWMutex{},
 
-		StateMtx:          &sync.Mutex{},
-		isDirty:           false,
-		refcntInitSuccess: false,
+		calcMtx:    &sync.Mutex{},
+		StateMtx:   &sync.Mutex{},
+		isDirty:    false,
+		state:      StateInitializing,
+		stateSince: time.Now(),
 	}
 }
 
@@ -159,7 +219,70 @@ func newRefCount() *refCount {
 
 // return if refcount initialization has been successful
 func (r *RefCountsMap) IsInitialized() bool {
-	return r.refcntInitSuccess
+	return r.GetState() == StateHealthy
+}
+
+// GetState returns the current plugin state
+func (r *RefCountsMap) GetState() PluginState {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.state
+}
+
+// GetStatus returns plugin state details, used for the status endpoint
+func (r *RefCountsMap) GetStatus() map[string]interface{} {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	status := map[string]interface{}{
+		"State":          r.state.String(),
+		"StateSince":     r.stateSince.Format(time.RFC3339),
+		"FailedAttempts": r.attempts,
+		"Volumes":        len(r.refMap),
+	}
+	if r.lastErr != nil {
+		status["LastError"] = r.lastErr.Error()
+	}
+	return status
+}
+
+// SetOrphanDryRun - when set, volumes attached to the VM but not used
+// are only logged during discovery instead of being detached
+func (r *RefCountsMap) SetOrphanDryRun(dryRun bool) {
+	r.orphanDryRun = dryRun
+}
+
+// NotReadyMsg returns the reason operations needing refcounts are refused
+func (r *RefCountsMap) NotReadyMsg() string {
+	if r.GetState() == StateDegraded {
+		return plugin_utils.PluginDegradedError
+	}
+	return plugin_utils.PluginInitError
+}
+
+// setState moves the plugin to a new state and logs the transition
+func (r *RefCountsMap) setState(state PluginState, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if err != nil {
+		r.attempts++
+		r.lastErr = err
+	} else {
+		r.attempts = 0
+		r.lastErr = nil
+	}
+	if r.state == state {
+		return
+	}
+	log.WithFields(log.Fields{
+		"from":     r.state.String(),
+		"to":       state.String(),
+		"attempts": r.attempts,
+		"error":    err,
+	}).Warning("Plugin state changed ")
+	r.state = state
+	r.stateSince = time.Now()
 }
 
 // dirty the background refcount process
@@ -176,44 +299,127 @@ func (r *RefCountsMap) Init(d drivers.VolumeDriver, mountDir string, name string
 	// If refcounting wasn't successful, schedule one again
 	if err != nil {
 		log.Infof("Refcounting failed: (%v).", err)
-		go func() {
-			r.retryCalculate(d, mountDir, name)
-		}()
+		r.setState(StateInitializing, err)
+		r.startRetry(d, mountDir, name)
+	}
+}
+
+// Reconcile rediscovers refcounts from Docker and syncs mounts with them,
+// as done on plugin start. On failure the refcounts and the state of a
+// healthy plugin are kept, otherwise discovery is retried in the background.
+func (r *RefCountsMap) Reconcile(d drivers.VolumeDriver) error {
+	log.Info("Reconciling refcounts on request ")
+	err := r.calculate(d, mountRoot, driverName)
+	if err != nil {
+		if r.IsInitialized() {
+			log.Warningf("Refcounting failed: (%v). Keeping current refcounts.", err)
+			return err
+		}
+		log.Infof("Refcounting failed: (%v).", err)
+		r.setState(StateInitializing, err)
+		r.startRetry(d, mountRoot, driverName)
+	}
+	return err
+}
+
+// startRetry retries refcount discovery in the background, unless retries
+// are already running
+func (r *RefCountsMap) startRetry(d drivers.VolumeDriver, mountDir string, name string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.retrying {
+		return
 	}
+	r.retrying = true
+	go func() {
+		r.retryCalculate(d, mountDir, name)
+		r.mtx.Lock()
+		r.retrying = false
+		r.mtx.Unlock()
+	}()
 }
 
 // create a timer to calculate refcount after a delay. If failed, retry again
-// until retry attempt limit reached
+// until retry attempt limit reached, then switch to degraded mode and keep
+// retrying at degradedRetryIntervalSec until refcounting succeeds
 func (r *RefCountsMap) retryCalculate(d drivers.VolumeDriver, mountDir string, name string) {
 	attemptLeft := refCountRetryAttempts
 	delay := refCountDelayStartSec
-	for attemptLeft > 0 {
-		// generate a random delay everytime
+	for {
 		log.Infof("Scheduling again after %d seconds", delay)
 		timer := time.NewTimer(time.Duration(delay) * time.Second)
 
 		<-timer.C
-		err := r.calculate(d, mountDir, driverName)
-		if err != nil {
-			log.Infof("Refcounting failed: (%v). Attempts left: %d ", err, attemptLeft)
+		err := r.calculate(d, mountDir, name)
+		if err == nil {
+			return // all good
+		}
+
+		if attemptLeft > 0 {
 			attemptLeft--
-			// exponential backoff
+			log.Infof("Refcounting failed: (%v). Attempts left: %d ", err, attemptLeft)
+			r.setState(StateInitializing, err)
+			// exponential backoff, capped by the degraded mode interval
 			delay += delay
-		} else {
-			return // all good
+			if delay > degradedRetryIntervalSec {
+				delay = degradedRetryIntervalSec
+			}
+			continue
 		}
+
+		// couldn't complete refcounting even after retries. Restarting
+		// the plugin would not help, so keep running in degraded mode.
+		log.Errorf("Failed to talk to docker to calculate volumes usage (%v). "+
+			"Running in degraded mode, please check docker.", err)
+		r.setState(StateDegraded, err)
+		delay = degradedRetryIntervalSec
+	}
+}
+
+// PingDocker checks the Docker API is reachable
+func PingDocker() error {
+	c, err := client.NewClient(DockerUSocket, ApiVersion, nil, defaultHeaders)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerConnTimeoutSec*time.Second)
+	defer cancel()
+	_, err = c.ServerVersion(ctx)
+	return err
+}
+
+// VolumeContainers returns the IDs of the containers, running or not,
+// which use the volume name
+func VolumeContainers(name string) ([]string, error) {
+	c, err := client.NewClient(DockerUSocket, ApiVersion, nil, defaultHeaders)
+	if err != nil {
+		return nil, err
 	}
-	// couldn't complete refcounting even after retries.
-	// docker logs artifical panic and restarts the plugin.
-	panic(fmt.Sprintf("Failed to talk to docker to calculate volumes usage. Please restart docker"))
+	ctx, cancel := context.WithTimeout(context.Background(), dockerConnTimeoutSec*time.Second)
+	defer cancel()
+	filter := filters.NewArgs()
+	filter.Add("volume", name)
+	containers, err := c.ContainerList(ctx, types.ContainerListOptions{All: true, Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(containers))
+	for _, ct := range containers {
+		ids = append(ids, ct.ID)
+	}
+	return ids, nil
 }
 
 // calculate Refcounts. Discover volume usage refcounts from Docker.
 func (r *RefCountsMap) calculate(d drivers.VolumeDriver, mountDir string, name string) error {
+	r.calcMtx.Lock()
+	defer r.calcMtx.Unlock()
+
 	c, err := client.NewClient(DockerUSocket, ApiVersion, nil, defaultHeaders)
 	if err != nil {
-		log.Panicf("Failed to create client for Docker at %s.( %v)",
+		log.Errorf("Failed to create client for Docker at %s.( %v)",
 			DockerUSocket, err)
+		return err
 	}
 	mountRoot = mountDir
 	driverName = name
@@ -264,16 +470,65 @@ func (r *RefCountsMap) GetCount(vol string) uint {
 	return rc.count
 }
 
+// GetCounts returns the refcounts of all volumes in use
+func (r *RefCountsMap) GetCounts() map[string]uint {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	counts := make(map[string]uint, len(r.refMap))
+	for vol, rc := range r.refMap {
+		if rc.count > 0 {
+			counts[vol] = rc.count
+		}
+	}
+	return counts
+}
+
+// GetRefCounts returns the refcount records of all volumes in use or
+// mounted. Mount details are refreshed on discovery only.
+func (r *RefCountsMap) GetRefCounts() map[string]interface{} {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	counts := make(map[string]interface{}, len(r.refMap))
+	for vol, rc := range r.refMap {
+		counts[vol] = map[string]interface{}{
+			"Count":   rc.count,
+			"Mounted": rc.mounted,
+			"Device":  rc.dev,
+			"Others":  rc.others,
+		}
+	}
+	return counts
+}
+
+// Clear drops the refcount of the volume vol and returns the dropped count
+func (r *RefCountsMap) Clear(vol string) uint {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rc := r.refMap[vol]
+	if rc == nil {
+		return 0
+	}
+	delete(r.refMap, vol)
+	return rc.count
+}
+
 // Incr refCount for the volume vol. Creates new entry if needed.
 func (r *RefCountsMap) Incr(vol string) uint {
 	// Locks the RefCountsMap
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
+	return incr(r.refMap, vol)
+}
 
-	rc := r.refMap[vol]
+// incr refCount for the volume vol in refMap
+func incr(refMap map[string]*refCount, vol string) uint {
+	rc := refMap[vol]
 	if rc == nil {
 		rc = newRefCount()
-		r.refMap[vol] = rc
+		refMap[vol] = rc
 	}
 	rc.count++
 	return rc.count
@@ -282,7 +537,7 @@ func (r *RefCountsMap) Incr(vol string) uint {
 // Decr recfcount for the volume vol and returns the new count
 // returns -1  for error (and resets count to 0)
 // also deletes the node from the map if refcount drops to 0
-func (r *RefCountsMap) Decr(vol string) (uint, error) {
+func (r *RefCountsMap) Decr(logger *log.Entry, vol string) (uint, error) {
 	// Locks the RefCountsMap
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
@@ -297,14 +552,14 @@ func (r *RefCountsMap) Decr(vol string) (uint, error) {
 		// it should be caught in previous check. So delete the entry (in case
 		// someone upstairs does 'recover', and panic.
 		delete(r.refMap, vol)
-		log.Warning("Decr: refcnt already 0 (rc.count=0), name=%s", vol)
+		logger.Warningf("Decr: refcnt already 0 (rc.count=0), name=%s", vol)
 		return 0, nil
 	}
 
 	rc.count--
 
 	if rc.count < 0 {
-		log.Warningf("Decr: Internal error, refcnt is negative. Trying to recover, deleting the counter - name=%s refcnt=%d", vol, rc.count)
+		logger.Warningf("Decr: Internal error, refcnt is negative. Trying to recover, deleting the counter - name=%s refcnt=%d", vol, rc.count)
 	}
 	// Deletes the refcount only if there are no references
 	if rc.count <= 0 {
@@ -313,36 +568,106 @@ func (r *RefCountsMap) Decr(vol string) (uint, error) {
 	return rc.count, nil
 }
 
-// check if volume with source as mount_source belongs to vmdk plugin
-func isVMDKMount(mount_source string) bool {
-	managedPluginMountStart := "/var/lib/docker/plugins/"
+// check if refcounting has been made dirty by mounts/unmounts
+func (r *RefCountsMap) checkDirty() bool {
+	r.StateMtx.Lock()
+	defer r.StateMtx.Unlock()
+	return r.isDirty
+}
+
+// result of inspecting a single container
+type inspectResult struct {
+	id     string
+	mounts []types.MountPoint
+	err    error
+}
 
-	// if plugin is used as a service
-	if strings.HasPrefix(mount_source, mountRoot) {
-		return true
+// inspectContainers inspects containers using a pool of at most inspectWorkers
+// goroutines and returns container ID -> mounts. A container which can't be
+// inspected doesn't fail the whole pass, its mounts as reported by
+// ContainerList are used instead.
+func (r *RefCountsMap) inspectContainers(c *client.Client, containers []types.Container) (map[string][]types.MountPoint, error) {
+	workers := inspectWorkers
+	if len(containers) < workers {
+		workers = len(containers)
 	}
-	// if plugin is used as managed plugin
-	// managed plugin has mount source in format:
-	// '/var/lib/docker/plugins/{plugin uuid}/rootfs/mnt/vmdk/{volume name}'
-	if strings.HasPrefix(mount_source, managedPluginMountStart) && strings.Contains(mount_source, mountRoot) {
-		return true
+
+	jobs := make(chan types.Container)
+	results := make(chan inspectResult)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for ct := range jobs {
+				if r.checkDirty() {
+					results <- inspectResult{id: ct.ID, err: errDirty}
+					continue
+				}
+				mounts, err := inspectContainer(c, ct)
+				results <- inspectResult{id: ct.ID, mounts: mounts, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, ct := range containers {
+			jobs <- ct
+		}
+		close(jobs)
+	}()
+
+	listed := make(map[string]types.Container, len(containers))
+	for _, ct := range containers {
+		listed[ct.ID] = ct
 	}
 
-	// mounted volume doesn't belong to vmdk_plugin
-	return false
+	var err error
+	mounts := make(map[string][]types.MountPoint, len(containers))
+	for range containers {
+		res := <-results
+		switch {
+		case res.err == errDirty:
+			err = errDirty
+		case res.err != nil:
+			ct := listed[res.id]
+			log.Warningf("ContainerInspect failed for %s (err: %v), using %d mounts from container list",
+				ct.Names, res.err, len(ct.Mounts))
+			mounts[res.id] = ct.Mounts
+		default:
+			mounts[res.id] = res.mounts
+		}
+	}
+	return mounts, err
 }
 
-// check if refcounting has been made dirty by mounts/unmounts
-func (r *RefCountsMap) checkDirty() bool {
-	r.StateMtx.Lock()
-	defer r.StateMtx.Unlock()
-	return r.isDirty
+// inspectContainer returns mounts of a container. Failed inspects are retried
+// up to inspectRetryAttempts times. A container which is gone by now has no mounts.
+func inspectContainer(c *client.Client, ct types.Container) ([]types.MountPoint, error) {
+	var err error
+	for attempt := 1; attempt <= inspectRetryAttempts; attempt++ {
+		var info types.ContainerJSON
+		ctx, cancel := context.WithTimeout(context.Background(), dockerConnTimeoutSec*time.Second)
+		info, err = c.ContainerInspect(ctx, ct.ID)
+		cancel()
+		if err == nil {
+			return info.Mounts, nil
+		}
+		if client.IsErrContainerNotFound(err) {
+			log.Debugf("Container %v is gone, skipping", ct.Names)
+			return nil, nil
+		}
+		log.Infof("ContainerInspect failed for %s (err: %v). Attempt %d of %d",
+			ct.Names, err, attempt, inspectRetryAttempts)
+		if attempt < inspectRetryAttempts {
+			time.Sleep(defaultSleepIntervalSec * time.Second)
+		}
+	}
+	return nil, err
 }
 
 // enumerates volumes and  builds RefCountsMap, then sync with mount info
 func (r *RefCountsMap) discoverAndSync(c *client.Client, d drivers.VolumeDriver) error {
-	// we assume to  have empty refcounts. Let's enforce
-
+	// counts are discovered into a new map, which replaces the current
+	// refcounts only on success. Mounts and unmounts keep using the
+	// current refcounts meanwhile, and dirty the discovery.
+	refMap := make(map[string]*refCount)
 	r.StateMtx.Lock()
 	r.isDirty = false
 	r.StateMtx.Unlock()
@@ -363,29 +688,28 @@ func (r *RefCountsMap) discoverAndSync(c *client.Client, d drivers.VolumeDriver)
 		return err
 	}
 
+	log.Infof("Found %d running or paused containers", len(containers))
+	mounts, err := r.inspectContainers(c, containers)
+	if err != nil {
+		return err
+	}
+
+	// our mounts, used to tell plugin volumes from others
+	entries, err := plugin_utils.GetMountInfoEntries()
+	if err != nil {
+		log.Errorf("Failed to read mount info (err: %v)", err)
+		return err
+	}
+	pluginMounts := plugin_utils.GetPluginMounts(entries, mountRoot)
+
 	// use same datastore for all volumes with short names
 	datastoreName := ""
 
-	log.Infof("Found %d running or paused containers", len(containers))
 	for _, ct := range containers {
-
-		if r.checkDirty() {
-			return fmt.Errorf("refcounting wasn't clean.")
-		}
-
-		ctx_inspect, cancel_inspect := context.WithTimeout(context.Background(), dockerConnTimeoutSec*time.Second)
-		defer cancel_inspect()
-		containerJSONInfo, err := c.ContainerInspect(ctx_inspect, ct.ID)
-		if err != nil {
-			log.Errorf("ContainerInspect failed for %s (err: %v)", ct.Names, err)
-			// We intentionally don't cleanup refMap because whatever refCounts(if any) we were able to
-			// populate are valid.
-			return err
-		}
 		log.Debugf("  Mounts for %v", ct.Names)
-		for _, mount := range containerJSONInfo.Mounts {
-			// check if the mount location belongs to vmdk plugin
-			if isVMDKMount(mount.Source) != true {
+		for _, mount := range mounts[ct.ID] {
+			// check if the mount belongs to the plugin
+			if !plugin_utils.IsPluginMount(mount.Driver, mount.Source, driverName, pluginMounts) {
 				continue
 			}
 
@@ -395,29 +719,41 @@ func (r *RefCountsMap) discoverAndSync(c *client.Client, d drivers.VolumeDriver)
 				return err
 			}
 			datastoreName = volumeInfo.DatastoreName
-			r.Incr(volumeInfo.VolumeName)
+			if incr(refMap, volumeInfo.VolumeName) == 1 {
+				// the first use of a pool volume is a use of its pool
+				if pool := getPool(d, volumeInfo.VolumeName); pool != "" {
+					incr(refMap, pool)
+				}
+			}
 			log.Debugf("name=%v (driver=%s source=%s) (%v)",
 				mount.Name, mount.Driver, mount.Source, mount)
 		}
 	}
 
 	// lock and check if the background refcount was dirtied.
-	// get mounts, remove unncessary mounts and set refcntInitSuccess
+	// get mounts, remove unncessary mounts and set the healthy state
 	// under same lock to avoid races with parallel mount/unmount
 	r.StateMtx.Lock()
 	defer r.StateMtx.Unlock()
 	if r.isDirty == true {
 		// refcounting was dirtied by parallel mount/unmount.
-		return fmt.Errorf("refcounting wasn't clean.")
+		return errDirty
 	}
 
 	// Check that refcounts and actual mount info from Linux match
 	// If they don't, unmount unneeded stuff, or yell if something is
 	// not mounted but should be (it's error. we should not get there)
-	r.updateRefMap()
+	if err = updateRefMap(refMap); err != nil {
+		log.Errorf("Failed to read mount info (err: %v)", err)
+		return err
+	}
+	r.mtx.Lock()
+	r.refMap = refMap
+	r.mtx.Unlock()
 	r.syncMountsWithRefCounters(d)
+	r.detachOrphans(d)
 	// mark reconciling success so that further unmounts can instantly be processed
-	r.refcntInitSuccess = true
+	r.setState(StateHealthy, nil)
 	return nil
 }
 
@@ -433,11 +769,20 @@ func (r *RefCountsMap) syncMountsWithRefCounters(d drivers.VolumeDriver) {
 			"refcnt":  cnt.count,
 			"mounted": cnt.mounted,
 			"dev":     cnt.dev,
+			"others":  cnt.others,
 		}
 
 		log.WithFields(f).Debug("Refcnt record: ")
+		if getPool(d, vol) != "" {
+			// pool volumes are directories of their pool, which is synced
+			continue
+		}
 		if cnt.mounted == true {
-			if cnt.count == 0 {
+			if cnt.count == 0 && len(cnt.others) > 0 {
+				// Not used by containers, but the filesystem is mounted
+				// elsewhere (e.g. bind mounted) - leave it alone
+				log.WithFields(f).Warning("Volume filesystem is also mounted elsewhere, skipping recovery unmount. ")
+			} else if cnt.count == 0 {
 				// Volume mounted but not used - UNMOUNT and DETACH !
 				log.WithFields(f).Info("Initiating recovery unmount. ")
 				err := d.UnmountVolume(vol)
@@ -486,25 +831,72 @@ func (r *RefCountsMap) syncMountsWithRefCounters(d drivers.VolumeDriver) {
 	}
 }
 
-// updates refcount map with mounted volumes using mount info
-func (r *RefCountsMap) updateRefMap() error {
-	r.mtx.Lock()
-	defer r.mtx.Unlock()
+// getPool returns the pool volume holding vol, or "" if vol isn't in a pool
+func getPool(d drivers.VolumeDriver, vol string) string {
+	resolver, ok := d.(drivers.PoolVolumeResolver)
+	if !ok {
+		return ""
+	}
+	return resolver.GetPool(vol)
+}
+
+// detach volumes attached to the VM which are neither mounted nor used
+func (r *RefCountsMap) detachOrphans(d drivers.VolumeDriver) {
+	lister, ok := d.(drivers.AttachedVolumesLister)
+	if !ok {
+		return
+	}
+
+	attached, err := lister.ListAttachedVolumes()
+	if err != nil {
+		log.Warningf("Failed to list volumes attached to this VM (%v), skipping orphan cleanup", err)
+		return
+	}
 
-	volumeMap, err := plugin_utils.GetMountInfo(mountRoot)
+	r.mtx.RLock()
+	orphans := make([]string, 0, len(attached))
+	for _, vol := range attached {
+		// mounted or used volumes are already handled by syncMountsWithRefCounters
+		if r.refMap[vol] == nil {
+			orphans = append(orphans, vol)
+		}
+	}
+	r.mtx.RUnlock()
 
+	for _, vol := range orphans {
+		f := log.Fields{"name": vol, "dryRun": r.orphanDryRun}
+		if r.orphanDryRun {
+			log.WithFields(f).Warning("Volume attached but not mounted or used, skipping detach (dry run) ")
+			continue
+		}
+		log.WithFields(f).Info("Volume attached but not mounted or used, initiating recovery detach ")
+		if err := lister.DetachVolume(vol); err != nil {
+			log.WithFields(f).Warning("Failed to detach - manual recovery may be needed")
+		}
+	}
+}
+
+// updates refcount map with mounted volumes using mount info
+func updateRefMap(refMap map[string]*refCount) error {
+	entries, err := plugin_utils.GetMountInfoEntries()
 	if err != nil {
 		return err
 	}
 
-	for volName, dev := range volumeMap {
-		refInfo := r.refMap[volName]
+	for volName, mount := range plugin_utils.GetPluginMounts(entries, mountRoot) {
+		refInfo := refMap[volName]
 		if refInfo == nil {
 			refInfo = newRefCount()
 		}
 		refInfo.mounted = true
-		refInfo.dev = dev
-		r.refMap[volName] = refInfo
+		refInfo.dev = mount.Source
+		refInfo.others = nil
+		for _, entry := range plugin_utils.GetDeviceMounts(entries, mount) {
+			if entry.MountID != mount.MountID {
+				refInfo.others = append(refInfo.others, entry.MountPoint)
+			}
+		}
+		refMap[volName] = refInfo
 		log.Debugf("Found '%s' in /proc/mount, ref=(%#v)", volName, refInfo)
 	}
 
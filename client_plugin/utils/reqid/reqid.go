@@ -0,0 +1,73 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reqid gives each Docker volume request an ID, which is added to
+// the log entries made while the request is served and sent to the ESX
+// service along with requests to it, so logs of the plugin and of ESX can
+// be matched up.
+//
+// The ID is passed explicitly: wrapped drivers taking request IDs
+// implement Driver, and serve the request from the driver WithRequestID
+// returns. The driver logs with the entry Log returns for the ID, and
+// passes it on to the fs and refcount functions it calls.
+package reqid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-plugins-helpers/volume"
+)
+
+const (
+	// Key - log field of request IDs
+	Key = "reqid"
+
+	idBytes = 8
+)
+
+// Driver is implemented by volume drivers which pass request IDs on
+type Driver interface {
+	// WithRequestID returns the driver serving the request with ID id
+	WithRequestID(id string) volume.Driver
+}
+
+// New returns a new request ID
+func New() string {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		// IDs only tag logs, a clash is harmless
+		log.WithFields(log.Fields{"err": err}).Warning("Failed to generate request ID ")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Log returns the log entry of the request with ID id, or an entry without
+// a request ID if id is empty, for work not serving a request
+func Log(id string) *log.Entry {
+	if id == "" {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return log.WithField(Key, id)
+}
+
+// WithID returns the driver serving the request with ID id, which is
+// driver itself if it doesn't take request IDs
+func WithID(driver volume.Driver, id string) volume.Driver {
+	if d, ok := driver.(Driver); ok {
+		return d.WithRequestID(id)
+	}
+	return driver
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reqid
+
+import (
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/stretchr/testify/assert"
+)
+
+// idDriver - a driver keeping the request ID it serves
+type idDriver struct {
+	volume.Driver
+	id string
+}
+
+func (d *idDriver) WithRequestID(id string) volume.Driver {
+	return &idDriver{id: id}
+}
+
+func TestNew(t *testing.T) {
+	id := New()
+	assert.Len(t, id, 2*idBytes)
+	assert.NotEqual(t, id, New())
+}
+
+func TestWithID(t *testing.T) {
+	d := &idDriver{}
+	served := WithID(d, "3f2a9c0d1e4b5a67")
+	assert.Equal(t, "3f2a9c0d1e4b5a67", served.(*idDriver).id)
+	assert.Equal(t, "", d.id, "the wrapped driver is unchanged")
+
+	var plain volume.Driver = &struct{ volume.Driver }{}
+	assert.Equal(t, plain, WithID(plain, "3f2a9c0d1e4b5a67"))
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package main
+
+// Client of the plugin admin interface, see plugin_server.AdminServer.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// adminURL - the host part is ignored, requests go to the admin sock
+	adminURL     = "http://vdvs"
+	adminTimeout = 5 * time.Minute // force unmounts and reconciles may take a while
+)
+
+// adminClient sends requests to the plugin admin sock
+type adminClient struct {
+	sockAddr string
+	client   *http.Client
+}
+
+// adminError - error field of admin responses
+type adminError struct {
+	Err string
+}
+
+func newAdminClient(sockAddr string) *adminClient {
+	dial := func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", sockAddr)
+	}
+	return &adminClient{
+		sockAddr: sockAddr,
+		client: &http.Client{
+			Transport: &http.Transport{Dial: dial},
+			Timeout:   adminTimeout,
+		},
+	}
+}
+
+// get sends GET path and decodes the response into result
+func (a *adminClient) get(path string, result interface{}) error {
+	return a.do("GET", path, nil, result)
+}
+
+// post sends POST path and decodes the response into result, if not nil
+func (a *adminClient) post(path string, result interface{}) error {
+	return a.do("POST", path, nil, result)
+}
+
+// do sends a request with the JSON encoding of body, if not nil
+func (a *adminClient) do(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, adminURL+path, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Plugin admin interface at %s is not reachable: %v", a.sockAddr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp adminError
+		if json.NewDecoder(resp.Body).Decode(&errResp) != nil || errResp.Err == "" {
+			return fmt.Errorf("%s %s failed: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("%s", errResp.Err)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
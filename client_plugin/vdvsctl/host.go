@@ -0,0 +1,200 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package main
+
+// vdvsctl commands about the plugin state on this Docker host
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/fs"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/plugin_utils"
+)
+
+// accessWrite - W_OK of access(2)
+const accessWrite = 2
+
+// mounts lists volume filesystems mounted in the mount root
+func mounts(ctl *vdvsctl, args []string) error {
+	entries, err := plugin_utils.GetMountInfoEntries()
+	if err != nil {
+		return err
+	}
+	pluginMounts := plugin_utils.GetPluginMounts(entries, ctl.mountRoot)
+	names := make([]string, 0, len(pluginMounts))
+	for name := range pluginMounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VOLUME\tMOUNTPOINT\tDEVICE\tFSTYPE\tOPTIONS\tOTHER MOUNTS")
+	for _, name := range names {
+		mount := pluginMounts[name]
+		others := len(plugin_utils.GetDeviceMounts(entries, mount)) - 1
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", name, mount.MountPoint, mount.Source,
+			mount.FsType, mount.Options, others)
+	}
+	return w.Flush()
+}
+
+// refCounts shows the plugin state, refcounts and Docker mount IDs
+func refCounts(ctl *vdvsctl, args []string) error {
+	var state refCountState
+	if err := ctl.admin.get("/refcounts", &state); err != nil {
+		return err
+	}
+	fmt.Printf("Plugin state: %v\n\n", state.Status["State"])
+
+	names := make([]string, 0, len(state.RefCounts))
+	for name := range state.RefCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VOLUME\tREFCOUNT\tMOUNT IDS")
+	for _, name := range names {
+		ids := 0
+		for _, volName := range state.MountIDs {
+			if volName == name {
+				ids++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", name, state.RefCounts[name].Count, ids)
+	}
+	return w.Flush()
+}
+
+// forceDetach detaches a volume through the plugin, optionally dropping
+// its refcount and unmounting it first
+func forceDetach(ctl *vdvsctl, args []string) error {
+	flags := flag.NewFlagSet("force-detach", flag.ContinueOnError)
+	unmount := flags.Bool("unmount", false, "Drop the refcount of the volume and unmount it first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("force-detach takes one volume name")
+	}
+	name := flags.Arg(0)
+
+	if *unmount {
+		// the plugin detaches the volume once it is unmounted
+		if err := ctl.admin.post("/volumes/"+name+"/unmount", nil); err != nil {
+			return err
+		}
+	} else if err := ctl.admin.post("/volumes/"+name+"/detach", nil); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s detached\n", name)
+	return nil
+}
+
+// doctor checks the plugin, ESX service and host setup, and fails if
+// anything is broken
+func doctor(ctl *vdvsctl, args []string) error {
+	failed := 0
+	report := func(check string, err error, warn bool) {
+		switch {
+		case err == nil:
+			fmt.Printf("[OK]   %s\n", check)
+		case warn:
+			fmt.Printf("[WARN] %s: %v\n", check, err)
+		default:
+			fmt.Printf("[FAIL] %s: %v\n", check, err)
+			failed++
+		}
+	}
+
+	// plugin health and refcounts
+	var state refCountState
+	err := ctl.admin.get("/refcounts", &state)
+	report("plugin admin interface", err, false)
+	if err == nil {
+		if state.Status["State"] != "healthy" {
+			err = fmt.Errorf("plugin is %v, last error: %v", state.Status["State"], state.Status["LastError"])
+		}
+		report("plugin refcounts", err, false)
+	}
+
+	// ESX service over vsock
+	_, err = ctl.ops.ListVMAttached()
+	report("ESX service over vsock", err, false)
+
+	// filesystem tools
+	report("mkfs for "+fs.FstypeDefault, fs.VerifyFSSupport(fs.FstypeDefault), false)
+
+	// mount root
+	err = checkMountRoot(ctl.mountRoot)
+	report("mount root "+ctl.mountRoot, err, false)
+	if err == nil && state.RefCounts != nil {
+		report("mounts of used volumes", checkMounts(ctl.mountRoot, state), true)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkMountRoot checks that the mount root is a writable directory
+func checkMountRoot(mountRoot string) error {
+	info, err := os.Stat(mountRoot)
+	if os.IsNotExist(err) {
+		// created by the plugin on first mount
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory")
+	}
+	return syscall.Access(mountRoot, accessWrite)
+}
+
+// checkMounts checks that volumes used by containers are mounted, and
+// mounted volumes are used
+func checkMounts(mountRoot string, state refCountState) error {
+	entries, err := plugin_utils.GetMountInfoEntries()
+	if err != nil {
+		return err
+	}
+	pluginMounts := plugin_utils.GetPluginMounts(entries, mountRoot)
+
+	var problems []string
+	for name, rc := range state.RefCounts {
+		if _, mounted := pluginMounts[name]; rc.Count > 0 && !mounted && state.PoolVolumes[name] == "" {
+			problems = append(problems, name+" is used but not mounted")
+		}
+	}
+	for name := range pluginMounts {
+		if state.RefCounts[name].Count == 0 {
+			problems = append(problems, name+" is mounted but not used")
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%v", problems)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+// vdvsctl - command line tool for the vSphere Docker volume plugin on the
+// Docker host. It talks to the plugin over its admin sock, and to ESX the
+// same way the plugin does.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/drivers/vmdk/vmdkops"
+	"github.com/vmware/docker-volume-vsphere/client_plugin/utils/config"
+)
+
+// defaultEsxPort - default port of the ESX service, as in the plugin config
+const defaultEsxPort = 1019
+
+// command - a vdvsctl command
+type command struct {
+	name  string
+	args  string
+	help  string
+	run   func(ctl *vdvsctl, args []string) error
+	nargs int // number of arguments, -1 if not checked
+}
+
+// vdvsctl - connections used by commands
+type vdvsctl struct {
+	admin     *adminClient
+	ops       vmdkops.VmdkOps
+	mountRoot string
+}
+
+var commands = []command{
+	{"volume ls", "", "List volumes, with their refcounts and attach state on this VM", volumeList, 0},
+	{"volume inspect", "VOLUME", "Show volume details from ESX and its state on this VM", volumeInspect, 1},
+	{"volume resize", "VOLUME SIZE", "Grow a detached volume to SIZE, e.g. 20gb", volumeResize, 2},
+	{"volume snapshot", "VOLUME SNAPSHOT", "Clone VOLUME to the new volume SNAPSHOT, freezing it if mounted", volumeSnapshot, 2},
+	{"mounts", "", "List volumes mounted by the plugin", mounts, 0},
+	{"refcounts", "", "Show plugin state, volume refcounts and Docker mount IDs", refCounts, 0},
+	{"doctor", "", "Check the plugin, ESX service and host setup", doctor, 0},
+	{"force-detach", "[-unmount] VOLUME", "Detach a volume which is not used, -unmount also drops its refcount and unmounts it", forceDetach, -1},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] COMMAND\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+// findCommand returns the command args start with, and the remaining args
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func main() {
+	adminSock := flag.String("admin_sock", config.DefaultVMDKPluginAdminSock, "Unix sock of the plugin admin interface")
+	port := flag.Int("port", defaultEsxPort, "Port of the ESX service")
+	mountRoot := flag.String("mount_root", config.MountRoot, "Directory the plugin mounts volumes in")
+	logLevel := flag.String("log_level", "warning", "Logging level, logs go to stderr")
+	flag.Usage = usage
+	flag.Parse()
+
+	level, err := log.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	log.SetOutput(os.Stderr)
+	log.SetLevel(level)
+
+	cmd, args := findCommand(flag.Args())
+	if cmd == nil || (cmd.nargs >= 0 && len(args) != cmd.nargs) {
+		usage()
+		os.Exit(2)
+	}
+
+	vmdkops.EsxPort = *port
+	ctl := &vdvsctl{
+		admin:     newAdminClient(*adminSock),
+		ops:       vmdkops.VmdkOps{Cmd: vmdkops.EsxVmdkCmd{Mtx: &sync.Mutex{}}},
+		mountRoot: *mountRoot,
+	}
+	if err = cmd.run(ctl, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
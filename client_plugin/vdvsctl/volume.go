@@ -0,0 +1,163 @@
+// Copyright 2017 VMware, Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+
+package main
+
+// vdvsctl volume commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	cloneFromOption = "clone-from"
+	// snapshotFreezeTimeout - longest a volume stays frozen for a snapshot
+	snapshotFreezeTimeout = "5m"
+)
+
+// refCountState - body of GET /refcounts
+type refCountState struct {
+	Status    map[string]interface{}
+	RefCounts map[string]struct {
+		Count   uint
+		Mounted bool
+		Device  string
+		Others  []string
+	}
+	MountIDs    map[string]string
+	PoolVolumes map[string]string
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// volumeList lists volumes from ESX, with their local refcounts
+func volumeList(ctl *vdvsctl, args []string) error {
+	volumes, err := ctl.ops.List()
+	if err != nil {
+		return err
+	}
+
+	attached := make(map[string]bool)
+	if vmVolumes, err := ctl.ops.ListVMAttached(); err == nil {
+		for _, vol := range vmVolumes {
+			attached[vol.Name] = true
+		}
+	} else {
+		log.WithFields(log.Fields{"error": err}).Warning("Failed to list volumes attached to this VM ")
+	}
+	var state refCountState
+	stateErr := ctl.admin.get("/refcounts", &state)
+	if stateErr != nil {
+		log.WithFields(log.Fields{"error": stateErr}).Warning("Failed to get refcounts from the plugin ")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VOLUME\tATTACHED\tREFCOUNT")
+	for _, vol := range volumes {
+		refcnt := "-"
+		if stateErr == nil {
+			refcnt = fmt.Sprint(state.RefCounts[vol.Name].Count)
+		}
+		fmt.Fprintf(w, "%s\t%t\t%s\n", vol.Name, attached[vol.Name], refcnt)
+	}
+	return w.Flush()
+}
+
+// volumeInspect shows volume details from ESX and the plugin
+func volumeInspect(ctl *vdvsctl, args []string) error {
+	name := args[0]
+	details := make(map[string]interface{})
+
+	// pool volumes are known to the plugin only
+	esx, esxErr := ctl.ops.Get(name)
+	if esxErr != nil {
+		details["ESXError"] = esxErr.Error()
+	} else {
+		details["ESX"] = esx
+	}
+	var local map[string]interface{}
+	if err := ctl.admin.get("/volumes/"+name, &local); err != nil {
+		if esxErr != nil {
+			return esxErr
+		}
+		details["LocalError"] = err.Error()
+	} else {
+		details["Local"] = local
+	}
+	return printJSON(details)
+}
+
+// volumeResize grows a volume, which must not be attached
+func volumeResize(ctl *vdvsctl, args []string) error {
+	name, size := args[0], args[1]
+
+	var local map[string]interface{}
+	if ctl.admin.get("/volumes/"+name, &local) == nil {
+		if attached, _ := local["Attached"].(bool); attached {
+			return fmt.Errorf("Volume %s is attached to this VM, stop its containers first", name)
+		}
+	}
+	if err := ctl.ops.Resize(name, size); err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s resized to %s, its filesystem grows on the next mount\n", name, size)
+	return nil
+}
+
+// volumeSnapshot clones a volume, frozen for the time of the clone if it
+// is mounted here, so the snapshot holds a consistent filesystem
+func volumeSnapshot(ctl *vdvsctl, args []string) error {
+	name, snapshot := args[0], args[1]
+
+	var local map[string]interface{}
+	frozen := false
+	if err := ctl.admin.get("/volumes/"+name, &local); err != nil {
+		log.WithFields(log.Fields{"name": name, "error": err}).Warning("Can't get volume state from the plugin, not freezing ")
+	} else if mounted, _ := local["Mounted"].(bool); mounted {
+		if err = ctl.admin.post("/volumes/"+name+"/freeze?timeout="+snapshotFreezeTimeout, nil); err != nil {
+			return fmt.Errorf("Failed to freeze volume %s: %v", name, err)
+		}
+		frozen = true
+	}
+
+	start := time.Now()
+	err := ctl.ops.Create(snapshot, map[string]string{cloneFromOption: name})
+	if frozen {
+		if thawErr := ctl.admin.post("/volumes/"+name+"/thaw", nil); thawErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to thaw volume %s: %v\n", name, thawErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Volume %s cloned to %s in %v (frozen: %t)\n", name, snapshot,
+		time.Since(start)/time.Second*time.Second, frozen)
+	return nil
+}
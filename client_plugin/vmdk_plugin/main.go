@@ -36,7 +36,7 @@ func main() {
 	cfg, err := config.InitConfig(config.DefaultVMDKPluginConfigPath, config.DefaultVMDKPluginLogPath,
 		config.VSphereDriver, config.VSphereDriver)
 	if err != nil {
-		log.Warning("Failed to initialize config variables for vmdk plugin")
+		log.WithFields(log.Fields{"err": err}).Error("Failed to initialize config variables for vmdk plugin ")
 		os.Exit(1)
 	}
 
@@ -45,7 +45,7 @@ func main() {
 		driver = photon.NewVolumeDriver(cfg.Target, cfg.Project,
 			cfg.Host, config.MountRoot)
 	} else if cfg.Driver == config.VSphereDriver {
-		driver = vmdk.NewVolumeDriver(cfg.Port, cfg.UseMockEsx, config.MountRoot, cfg.Driver)
+		driver = vmdk.NewVolumeDriver(cfg, config.MountRoot)
 	} else {
 		log.Warning("Unknown driver or invalid/missing driver options, exiting - ", cfg.Driver)
 		os.Exit(1)
@@ -56,5 +56,5 @@ func main() {
 		os.Exit(1)
 	}
 
-	plugin_server.StartServer(cfg.Driver, &driver)
+	plugin_server.StartServer(cfg.Driver, &driver, cfg)
 }
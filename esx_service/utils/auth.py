@@ -34,6 +34,7 @@ CMD_REMOVE = 'remove'
 CMD_ATTACH = 'attach'
 CMD_DETACH = 'detach'
 CMD_GET    = 'get'
+CMD_RESIZE = 'resize'
 
 SIZE = 'size'
 
@@ -355,6 +356,19 @@ def check_privileges_for_command(cmd, opts, tenant_uuid, datastore_url, privileg
             result = error_code_to_message[ErrorCode.PRIVILEGE_NO_DELETE_PRIVILEGE]
             return result
 
+    if cmd == CMD_RESIZE:
+        # the usage quota is checked with authorize_grow() once the growth is known
+        if not has_privilege(privileges, auth_data_const.COL_ALLOW_CREATE):
+            result = error_code_to_message[ErrorCode.PRIVILEGE_NO_CREATE_PRIVILEGE]
+            return result
+        vol_size_in_MB = convert.convert_to_MB(get_vol_size(opts))
+        if vol_size_in_MB == 0:
+            result = error_code_to_message[ErrorCode.OPT_VOLUME_SIZE_INVALID]
+            return result
+        if not check_max_volume_size(vol_size_in_MB, privileges):
+            result = error_code_to_message[ErrorCode.PRIVILEGE_MAX_VOL_EXCEED]
+            return result
+
 def err_msg_no_table(table_name):
     error_msg = "table " + table_name + " does not exist"
     logging.error(error_msg)
@@ -499,6 +513,55 @@ def add_volume_to_volumes_table(tenant_uuid, datastore_url, vol_name, vol_size_i
 
     return None
 
+def authorize_grow(tenant_uuid, datastore_url, grow_size_in_MB):
+    """
+        Check whether a volume can grow by grow_size_in_MB without violating
+        the usage quota.
+        Return None on success or error string.
+    """
+    err_msg, _auth_mgr = get_auth_mgr()
+    if err_msg:
+        return err_msg
+
+    if _auth_mgr.allow_all_access():
+        return None
+
+    error_msg, privileges = get_privileges(tenant_uuid, datastore_url)
+    if error_msg:
+        return error_msg
+    if not check_usage_quota(grow_size_in_MB, tenant_uuid, datastore_url, privileges):
+        return error_code_to_message[ErrorCode.PRIVILEGE_USAGE_QUOTA_EXCEED]
+    return None
+
+def update_volume_size_in_volumes_table(tenant_uuid, datastore_url, vol_name, vol_size_in_MB):
+    """
+        Update size of volume in volumes table.
+        Return None on success or error string.
+    """
+    err_msg, _auth_mgr = get_auth_mgr()
+    if err_msg:
+        return err_msg
+
+    logging.debug("update size in volumes table(%s %s %s %s)", tenant_uuid, datastore_url,
+                  vol_name, vol_size_in_MB)
+
+    if _auth_mgr.allow_all_access():
+        logging.debug("Skipping volume size update in DB %s (allow_all_access)", tenant_uuid)
+        return None
+
+    try:
+        _auth_mgr.conn.execute(
+            "UPDATE volumes SET volume_size = ? WHERE tenant_id = ? AND datastore_url = ? AND volume_name = ?",
+            (vol_size_in_MB, tenant_uuid, datastore_url, vol_name)
+            )
+        _auth_mgr.conn.commit()
+    except sqlite3.Error as e:
+        logging.error("Error %s when updating volumes table for tenant_id %s and datastore_url %s",
+                      e, tenant_uuid, datastore_url)
+        return str(e)
+
+    return None
+
 def remove_volume_from_volumes_table(tenant_uuid, datastore_url, vol_name):
     """
         Remove volume from volumes table.
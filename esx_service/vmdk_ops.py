@@ -28,6 +28,9 @@ Commands ("cmd" in request):
 		"get"    - get info about an individual volume (vmdk)
 		"attach" - attach a VMDK to the requesting VM
 		"detach" - detach a VMDK from the requesting VM (assuming it's unmounted)
+		"list_vm_attached" - enumerate VMDKs attached to the requesting VM
+		"resize" - grow a detached VMDK to "size" in options
+		"ping"   - check the service is up, no volume is involved
 
 '''
 
@@ -370,12 +373,20 @@ def validate_opts(opts, vmdk_path):
      * size - The size of the disk to create
      * vsan-policy-name - The name of an existing policy to use
      * diskformat - The allocation format of allocated disk
+     * trim - When the plugin trims the filesystem
+     * encrypt, encrypt-cipher, encrypt-key-id - Encryption in the guest
+     * class - The class of create options in the plugin configuration
+     * mkfs-options - Options of the mkfs command
     """
     valid_opts = [kv.SIZE, kv.VSAN_POLICY_NAME, kv.DISK_ALLOCATION_FORMAT,
-                  kv.ATTACH_AS, kv.ACCESS, kv.FILESYSTEM_TYPE, kv.CLONE_FROM]
+                  kv.ATTACH_AS, kv.ACCESS, kv.FILESYSTEM_TYPE, kv.CLONE_FROM,
+                  kv.TRIM, kv.ENCRYPT, kv.ENCRYPT_CIPHER, kv.ENCRYPT_KEY_ID,
+                  kv.CLASS, kv.MKFS_OPTIONS]
     defaults = [kv.DEFAULT_DISK_SIZE, kv.DEFAULT_VSAN_POLICY,\
                 kv.DEFAULT_ALLOCATION_FORMAT, kv.DEFAULT_ATTACH_AS,\
-                kv.DEFAULT_ACCESS, kv.DEFAULT_FILESYSTEM_TYPE, kv.DEFAULT_CLONE_FROM]
+                kv.DEFAULT_ACCESS, kv.DEFAULT_FILESYSTEM_TYPE, kv.DEFAULT_CLONE_FROM,\
+                kv.DEFAULT_TRIM, kv.DEFAULT_ENCRYPT, kv.DEFAULT_ENCRYPT_CIPHER,\
+                kv.DEFAULT_ENCRYPT_KEY_ID, kv.DEFAULT_CLASS, kv.DEFAULT_MKFS_OPTIONS]
     invalid = frozenset(opts.keys()).difference(valid_opts)
     if len(invalid) != 0:
         msg = 'Invalid options: {0} \n'.format(list(invalid)) \
@@ -398,6 +409,11 @@ def validate_opts(opts, vmdk_path):
         validate_access(opts[kv.ACCESS])
     if kv.FILESYSTEM_TYPE in opts:
         validate_fstype(opts[kv.FILESYSTEM_TYPE], clone)
+    if kv.MKFS_OPTIONS in opts and clone:
+        raise ValidationError("Cannot define the mkfs options for a clone")
+    if kv.TRIM in opts:
+        validate_trim(opts[kv.TRIM])
+    validate_encrypt(opts, clone)
 
 
 def validate_size(size, clone=False):
@@ -483,6 +499,32 @@ def validate_access(access_type):
                              " Valid options are: {1}".format(access_type,
                                                               kv.ACCESS_TYPES))
 
+def validate_trim(trim):
+    """
+    Ensure that we recognize the trim mode
+    """
+    if not trim in kv.TRIM_TYPES:
+       raise ValidationError("Trim mode '{0}' is not supported."
+                             " Valid options are: {1}".format(trim, kv.TRIM_TYPES))
+
+def validate_encrypt(opts, clone=False):
+    """
+    Ensure that we recognize the encryption, and the cipher and key ID are
+    given only for encrypted volumes. A clone keeps the encryption of its source.
+    """
+    encrypt = opts.get(kv.ENCRYPT, kv.DEFAULT_ENCRYPT)
+    if not encrypt in kv.ENCRYPT_TYPES:
+        raise ValidationError("Encryption '{0}' is not supported."
+                              " Valid options are: {1}".format(encrypt, kv.ENCRYPT_TYPES))
+    if clone and kv.ENCRYPT in opts:
+        raise ValidationError("Cannot define the encryption for a clone")
+    for opt in [kv.ENCRYPT_CIPHER, kv.ENCRYPT_KEY_ID]:
+        if opt in opts and encrypt != kv.ENCRYPT_LUKS:
+            raise ValidationError("Option {0} is valid only with {1}={2}".format(
+                                  opt, kv.ENCRYPT, kv.ENCRYPT_LUKS))
+        if opt in opts and not opts[opt]:
+            raise ValidationError("Option {0} can't be empty".format(opt))
+
 def validate_fstype(fstype, clone=False):
     """
     Ensure that we don't accept fstype for a clone
@@ -548,6 +590,17 @@ def vol_info(vol_meta, vol_size_info, datastore):
           vinfo[kv.CLONE_FROM] = vol_meta[kv.VOL_OPTS][kv.CLONE_FROM]
        else:
           vinfo[kv.CLONE_FROM] = kv.DEFAULT_CLONE_FROM
+       if kv.TRIM in vol_meta[kv.VOL_OPTS]:
+          vinfo[kv.TRIM] = vol_meta[kv.VOL_OPTS][kv.TRIM]
+       else:
+          vinfo[kv.TRIM] = kv.DEFAULT_TRIM
+       if kv.ENCRYPT in vol_meta[kv.VOL_OPTS]:
+          vinfo[kv.ENCRYPT] = vol_meta[kv.VOL_OPTS][kv.ENCRYPT]
+       else:
+          vinfo[kv.ENCRYPT] = kv.DEFAULT_ENCRYPT
+       for opt in [kv.ENCRYPT_CIPHER, kv.ENCRYPT_KEY_ID, kv.CLASS, kv.MKFS_OPTIONS]:
+          if opt in vol_meta[kv.VOL_OPTS]:
+             vinfo[opt] = vol_meta[kv.VOL_OPTS][opt]
 
     return vinfo
 
@@ -623,6 +676,60 @@ def removeVMDK(vmdk_path, vol_name=None, vm_name=None, tenant_uuid=None, datasto
     return None
 
 
+# Return error, or None for OK
+def resizeVMDK(vmdk_path, vol_name, opts, tenant_uuid=None, datastore_url=None):
+    """
+    Grows a detached volume to the size in opts. The filesystem on the
+    volume is grown by the plugin when the volume is mounted next time.
+    Shrinking is not supported.
+    """
+    logging.info("*** resizeVMDK: %s opts=%s", vmdk_path, opts)
+
+    if not os.path.isfile(vmdk_path):
+        return err("Volume {0} not found.".format(vol_name))
+
+    new_size_in_MB = convert.convert_to_MB(opts.get(kv.SIZE, ""))
+    if new_size_in_MB == 0:
+        return err(error_code_to_message[ErrorCode.OPT_VOLUME_SIZE_INVALID])
+
+    attached, uuid, attach_as, attached_vm_name = getStatusAttached(vmdk_path)
+    if attached:
+        return err("Failed to resize volume {0}, in use by VM = {1}.".format(vol_name, attached_vm_name))
+
+    size_info = kv.get_vol_info(vmdk_path)
+    if not size_info:
+        return err("Failed to get size of volume {0}".format(vol_name))
+    cur_size_in_MB = convert.convert_to_MB(size_info[SIZE])
+    if new_size_in_MB <= cur_size_in_MB:
+        return err("Volume {0} is {1}, volumes can only grow".format(vol_name, size_info[SIZE]))
+
+    if tenant_uuid:
+        error_info = auth.authorize_grow(tenant_uuid, datastore_url, new_size_in_MB - cur_size_in_MB)
+        if error_info:
+            return err(error_info)
+
+    si = get_si()
+    task = si.content.virtualDiskManager.ExtendVirtualDisk(
+        name=vmdk_utils.get_datastore_path(vmdk_path),
+        newCapacityKb=new_size_in_MB * 1024, eagerZero=False)
+    try:
+        wait_for_tasks(si, [task])
+    except vim.fault.VimFault as ex:
+        return err("Failed to resize volume: {0}".format(ex.msg))
+
+    # keep the size in volume metadata in sync
+    vol_meta = kv.getAll(vmdk_path)
+    if vol_meta and kv.VOL_OPTS in vol_meta:
+        vol_meta[kv.VOL_OPTS][kv.SIZE] = opts[kv.SIZE]
+        if not kv.setAll(vmdk_path, vol_meta):
+            logging.warning("Failed to save size of %s to volume metadata", vmdk_path)
+
+    logging.info("Resized %s from %sMB to %sMB", vmdk_path, cur_size_in_MB, new_size_in_MB)
+    if tenant_uuid:
+        return auth.update_volume_size_in_volumes_table(tenant_uuid, datastore_url, vol_name, new_size_in_MB)
+    return None
+
+
 def getVMDK(vmdk_path, vol_name, datastore):
     """Checks if the volume exists, and returns error if it does not"""
     # Note: will return more Volume info here, when Docker API actually accepts it
@@ -657,6 +764,41 @@ def listVMDK(tenant):
             for x in vmdks]
 
 
+def listVMAttachedVMDK(vm_name, bios_uuid, vc_uuid):
+    """
+    Returns a list of docker volumes attached to the VM, each volume name
+    returned as `volume@datastore`. The guest uses it to find volumes which
+    are attached but not mounted (e.g. after a crash between attach and mount)
+    """
+    vm = None
+    if vc_uuid:
+        vm = findVmByUuid(vc_uuid)
+    if not vm:
+        vm = findVmByUuid(bios_uuid)
+    if not vm:
+        msg = "Failed to find VM object for %s (bios %s vc %s)" % (vm_name, bios_uuid, vc_uuid)
+        logging.error(msg)
+        return err(msg)
+
+    result = []
+    for d in vm.config.hardware.device:
+        vmdk_path = vmdk_utils.find_dvs_volume(d)
+        if not vmdk_path:
+            continue
+        datastore = vmdk_utils.get_datastore_from_vmdk_path(vmdk_path)
+        # where the disk is attached, the guest removes the disk device before detach
+        attributes = {}
+        controller = [c for c in vm.config.hardware.device
+                      if isinstance(c, vim.VirtualController) and c.key == d.controllerKey]
+        if controller and get_controller_type(controller[0])[0]:
+            info = dev_info(d.unitNumber, get_controller_pci_slot(vm, controller[0]),
+                            controller[0], get_disk_uuid(vm, d))
+            attributes = dict((k, v) for k, v in info.items() if v is not None)
+        result.append({u'Name': get_full_vol_name(os.path.basename(vmdk_path), datastore),
+                       u'Attributes': attributes})
+    return result
+
+
 # Return VM managed object, reconnect if needed. Throws if connection fails twice.
 # returns None if the uuid is not found
 def findVmByUuid(vm_uuid):
@@ -848,8 +990,18 @@ def authorize_check(vm_uuid, datastore_url, cmd, opts, use_default_ds, datastore
     return None
 
 
+def request_thread_name(name, request_id):
+    """
+    Returns the thread name <name> tagged with the ID the client gave the request,
+    so the ID shows in all log records of the request
+    """
+    if request_id:
+        return "{0}-{1}".format(name, request_id)
+    return name
+
+
 # gets the requests, calculates path for volumes, and calls the relevant handler
-def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_uuid=None):
+def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_uuid=None, request_id=None):
     """
     Executes a <cmd> request issused from a VM.
     The request is about volume <full_volume_name> in format volume@datastore.
@@ -858,6 +1010,7 @@ def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_u
     the one where the VM resides is used is "default_datastore" is not specified.
     For VM, the function gets vm_uuid, vm_name and config_path
     <opts> is a json options string blindly passed to a specific operation
+    <request_id> is the ID the client gave the request, logged with the request
 
     Returns None (if all OK) or error string
     """
@@ -872,7 +1025,7 @@ def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_u
         # For docker volume ls, docker prints a list of cached volume names in case
         # of error(in this case, orphan VM). See Issue #990
         # Explicity providing empty list of volumes to avoid misleading output.
-        if (cmd == "list") and (not tenant_uuid):
+        if (cmd == "list" or cmd == "list_vm_attached") and (not tenant_uuid):
             return []
         else:
             return err(error_info)
@@ -893,10 +1046,14 @@ def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_u
                   vm_uuid, vc_uuid, vm_name, tenant_name, default_datastore)
 
     if cmd == "list":
-        threadutils.set_thread_name("{0}-nolock-{1}".format(vm_name, cmd))
+        threadutils.set_thread_name(request_thread_name("{0}-nolock-{1}".format(vm_name, cmd), request_id))
         # if default_datastore is not set, should return error
         return listVMDK(tenant_name)
 
+    if cmd == "list_vm_attached":
+        threadutils.set_thread_name(request_thread_name("{0}-nolock-{1}".format(vm_name, cmd), request_id))
+        return listVMAttachedVMDK(vm_name=vm_name, bios_uuid=vm_uuid, vc_uuid=vc_uuid)
+
     try:
         vol_name, datastore = parse_vol_name(full_vol_name)
     except ValidationError as ex:
@@ -944,7 +1101,7 @@ def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_u
     # Lock name defaults to combination of DS,tenant name and vol name
     lockname = "{}.{}.{}".format(vm_datastore, tenant_name, vol_name)
     # Set thread name to vm_name-lockname
-    threadutils.set_thread_name("{0}-{1}".format(vm_name, lockname))
+    threadutils.set_thread_name(request_thread_name("{0}-{1}".format(vm_name, lockname), request_id))
 
     # Get a lock for the volume
     logging.debug("Trying to acquire lock: %s", lockname)
@@ -968,6 +1125,13 @@ def executeRequest(vm_uuid, vm_name, config_path, cmd, full_vol_name, opts, vc_u
                                   tenant_uuid=tenant_uuid,
                                   datastore_url=datastore_url)
 
+        elif cmd == "resize":
+            response = resizeVMDK(vmdk_path=vmdk_path,
+                                  vol_name=vol_name,
+                                  opts=opts,
+                                  tenant_uuid=tenant_uuid,
+                                  datastore_url=datastore_url)
+
         # For attach/detach reconfigure tasks, hold a per vm lock.
         elif cmd == "attach":
             with lockManager.get_lock(vm_uuid):
@@ -1067,21 +1231,35 @@ def findDeviceByPath(vmdk_path, vm):
             return d
     return None
 
+# Controller types reported to the client, the client locates the disk
+# according to the type. Newer controller types may be missing in pyVmomi.
+CONTROLLER_TYPES = [('ParaVirtualSCSIController', 'pvscsi', 'scsi'),
+                    ('VirtualLsiLogicController', 'lsilogic', 'scsi'),
+                    ('VirtualLsiLogicSASController', 'lsilogic-sas', 'scsi'),
+                    ('VirtualBusLogicController', 'buslogic', 'scsi'),
+                    ('VirtualAHCIController', 'sata', 'sata'),
+                    ('VirtualNVMEController', 'nvme', 'nvme')]
+
+def get_controller_type(controller):
+    ''' Return (type, VM config key prefix) of the given controller '''
+    for vim_type, controller_type, prefix in CONTROLLER_TYPES:
+        if hasattr(vim, vim_type) and isinstance(controller, getattr(vim, vim_type)):
+            return controller_type, prefix
+    return None, None
+
 # Find the PCI slot number
-def get_controller_pci_slot(vm, pvscsi, key_offset):
-    ''' Return PCI slot number of the given PVSCSI controller
+def get_controller_pci_slot(vm, controller):
+    ''' Return PCI slot number of the given controller
     Input parameters:
     vm: VM configuration
-    pvscsi: given PVSCSI controller
-    key_offset: offset from the bus number, controller_key - key_offset
-    is equal to the slot number of this given PVSCSI controller
+    controller: given PVSCSI, LSI, SATA or NVMe controller
     '''
-    if pvscsi.slotInfo:
-       return str(pvscsi.slotInfo.pciSlotNumber)
+    if controller.slotInfo:
+       return str(controller.slotInfo.pciSlotNumber)
     else:
        # Slot number is got from from the VM config
-       key = 'scsi{0}.pciSlotNumber'.format(pvscsi.key -
-                                            key_offset)
+       _, prefix = get_controller_type(controller)
+       key = '{0}{1}.pciSlotNumber'.format(prefix, controller.busNumber)
        slot = [cfg for cfg in vm.config.extraConfig \
                if cfg.key == key]
        # If the given controller exists
@@ -1090,10 +1268,26 @@ def get_controller_pci_slot(vm, pvscsi, key_offset):
        else:
           return None
 
-def dev_info(unit_number, pci_slot_number):
+def get_disk_uuid(vm, device):
+    ''' Return UUID of the disk device if the guest sees it as the disk
+    serial/WWN, i.e. disk.EnableUUID is set for the VM, or None
+    '''
+    enabled = [cfg for cfg in vm.config.extraConfig
+               if cfg.key.lower() == 'disk.enableuuid' and str(cfg.value).upper() == 'TRUE']
+    if not enabled or not device:
+        return None
+    return getattr(device.backing, 'uuid', None)
+
+def dev_info(unit_number, pci_slot_number, controller, disk_uuid=None):
     '''Return a dictionary with Unit/Bus for the vmdk (or error)'''
-    return {'Unit': str(unit_number),
-            'ControllerPciSlotNumber': pci_slot_number}
+    controller_type, _ = get_controller_type(controller)
+    info = {'Unit': str(unit_number),
+            'ControllerPciSlotNumber': pci_slot_number,
+            'ControllerType': controller_type,
+            'Bus': str(controller.busNumber)}
+    if disk_uuid:
+        info['DiskUUID'] = disk_uuid
+    return info
 
 def reset_vol_meta(vmdk_path):
     '''Clears metadata for vmdk_path'''
@@ -1296,14 +1490,20 @@ def disk_attach(vmdk_path, vm):
         logging.warning("Disk %s already attached. VM=%s",
                         vmdk_path, vm.config.uuid)
         setStatusAttached(vmdk_path, vm)
-        # Get that controller to which the device is configured for
-        pvsci = [d for d in controllers
-                   if type(d) == vim.ParaVirtualSCSIController and
+        # Get that controller to which the device is configured for,
+        # it may be any type if the disk was attached by someone else
+        controller = [d for d in devices
+                      if isinstance(d, vim.VirtualController) and
                       d.key == device.controllerKey]
+        if not controller or get_controller_type(controller[0])[0] is None:
+            msg = "Disk {0} is attached to an unsupported controller".format(vmdk_path)
+            logging.error(msg + " VM=%s", vm.config.uuid)
+            return err(msg)
 
         return dev_info(device.unitNumber,
-                        get_controller_pci_slot(vm, pvsci[0],
-                                                offset_from_bus_number))
+                        get_controller_pci_slot(vm, controller[0]),
+                        controller[0],
+                        get_disk_uuid(vm, device))
 
 
     # Disk isn't attached, make sure we have a PVSCI and add it if we don't
@@ -1314,9 +1514,9 @@ def disk_attach(vmdk_path, vm):
     if len(pvsci) > 0:
         idx, disk_slot = find_available_disk_slot(vm, devices, pvsci, offset_from_bus_number);
         if (disk_slot is not None):
-            controller_key = pvsci[idx].key
-            pci_slot_number = get_controller_pci_slot(vm, pvsci[idx],
-                                                      offset_from_bus_number)
+            controller = pvsci[idx]
+            controller_key = controller.key
+            pci_slot_number = get_controller_pci_slot(vm, controller)
             logging.debug("Find an available disk slot, controller_key=%d, slot_id=%d",
                           controller_key, disk_slot)
 
@@ -1340,8 +1540,8 @@ def disk_attach(vmdk_path, vm):
         pvsci = [d for d in devices
                  if type(d) == vim.ParaVirtualSCSIController and
                  d.key == controller_key]
-        pci_slot_number = get_controller_pci_slot(vm, pvsci[0],
-                                                  offset_from_bus_number)
+        controller = pvsci[0]
+        pci_slot_number = get_controller_pci_slot(vm, controller)
         logging.info("Added a PVSCSI controller, controller_key=%d pci_slot_number=%s",
                       controller_key, pci_slot_number)
 
@@ -1378,7 +1578,9 @@ def disk_attach(vmdk_path, vm):
                 msg += "(Current VM)"
         return err(msg)
 
-    vm_dev_info = dev_info(disk_slot, pci_slot_number)
+    # UUID is assigned to the disk by the reconfigure
+    vm_dev_info = dev_info(disk_slot, pci_slot_number, controller,
+                           get_disk_uuid(vm, findDeviceByPath(vmdk_path, vm)))
 
     setStatusAttached(vmdk_path, vm, vm_dev_info)
     logging.info("Disk %s successfully attached. controller pci_slot_number=%s, disk_slot=%d",
@@ -1481,10 +1683,11 @@ def set_vol_opts(name, tenant_name, options):
        logging.warning(msg)
        return False
 
-    # For now only allow resetting the access and attach-as options.
+    # For now only allow resetting the access, attach-as and trim options.
     valid_opts = {
         kv.ACCESS : kv.ACCESS_TYPES,
-        kv.ATTACH_AS : kv.ATTACH_AS_TYPES
+        kv.ATTACH_AS : kv.ATTACH_AS_TYPES,
+        kv.TRIM : kv.TRIM_TYPES
     }
 
     invalid = frozenset(opts.keys()).difference(valid_opts.keys())
@@ -1570,6 +1773,8 @@ def execRequestThread(client_socket, cartel, request):
             reply_string = {u'Error': "Failed to parse json '%s'." % request}
             send_vmci_reply(client_socket, reply_string)
         else:
+            request_id = req.get("reqid")
+            threadutils.set_thread_name(request_thread_name(threadutils.get_thread_name(), request_id))
             logging.debug("execRequestThread: req=%s", req)
             # If req from client does not include version number, set the version to
             # SERVER_PROTOCOL_VERSION by default to make backward compatible
@@ -1586,6 +1791,12 @@ def execRequestThread(client_socket, cartel, request):
                 logging.warning("executeRequest '%s' failed: %s", req["cmd"], reply_string)
                 return
 
+            # health checks of the client, kept out of the info level log
+            if req["cmd"] == "ping":
+                logging.debug("execRequestThread: ping from %s", vm_name)
+                send_vmci_reply(client_socket, None)
+                return
+
             opts = req["details"]["Opts"] if "Opts" in req["details"] else {}
             reply_string = executeRequest(
                                 vm_uuid=vm_uuid,
@@ -1594,7 +1805,8 @@ def execRequestThread(client_socket, cartel, request):
                                 config_path=cfg_path,
                                 cmd=req["cmd"],
                                 full_vol_name=req["details"]["Name"],
-                                opts=opts)
+                                opts=opts,
+                                request_id=request_id)
 
             logging.info("executeRequest '%s' completed with ret=%s", req["cmd"], reply_string)
             send_vmci_reply(client_socket, reply_string)
@@ -385,11 +385,15 @@ class ValidationTestCase(unittest.TestCase):
                     vmdk_ops.validate_opts({volume_kv.SIZE: s}, self.path)
                     vmdk_ops.validate_opts({volume_kv.VSAN_POLICY_NAME: p}, self.path)
                     vmdk_ops.validate_opts({volume_kv.DISK_ALLOCATION_FORMAT: d}, self.path)
+        for t in volume_kv.TRIM_TYPES:
+            vmdk_ops.validate_opts({volume_kv.TRIM: t}, self.path)
+        vmdk_ops.validate_opts({volume_kv.CLASS: 'gold', volume_kv.MKFS_OPTIONS: '-i 8192'}, self.path)
 
     def test_failure(self):
         bad = [{volume_kv.SIZE: '2'}, {volume_kv.VSAN_POLICY_NAME: 'bad-policy'},
         {volume_kv.DISK_ALLOCATION_FORMAT: 'thiN'}, {volume_kv.SIZE: 'mb'}, {'bad-option': '4'}, {'bad-option': 'what',
-                                                             volume_kv.SIZE: '4mb'}]
+                                                             volume_kv.SIZE: '4mb'},
+        {volume_kv.TRIM: 'always'}, {volume_kv.CLONE_FROM: 'vol1', volume_kv.MKFS_OPTIONS: '-i 8192'}]
         for opts in bad:
             with self.assertRaises(vmdk_ops.ValidationError):
                 vmdk_ops.validate_opts(opts, self.path)
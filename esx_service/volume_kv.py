@@ -92,6 +92,35 @@ DEFAULT_FILESYSTEM_TYPE = 'ext4'
 CLONE_FROM = 'clone-from' # clone volume parent
 DEFAULT_CLONE_FROM = 'None'
 
+# Filesystem trim (discard of unused blocks), handled in the volume-plugin
+# at the docker host, and tracked in volume metadata.
+TRIM = 'trim'
+TRIM_OFF = 'off'
+TRIM_ON_UNMOUNT = 'on-unmount'
+TRIM_PERIODIC = 'periodic'
+DEFAULT_TRIM = TRIM_OFF
+TRIM_TYPES = [TRIM_OFF, TRIM_ON_UNMOUNT, TRIM_PERIODIC]
+
+# Encryption in the guest, handled in the volume-plugin at the docker host,
+# and tracked in volume metadata. The key itself never leaves the docker host.
+ENCRYPT = 'encrypt'
+ENCRYPT_NONE = 'none'
+ENCRYPT_LUKS = 'luks'
+DEFAULT_ENCRYPT = ENCRYPT_NONE
+ENCRYPT_TYPES = [ENCRYPT_NONE, ENCRYPT_LUKS]
+ENCRYPT_CIPHER = 'encrypt-cipher'
+ENCRYPT_KEY_ID = 'encrypt-key-id'
+DEFAULT_ENCRYPT_CIPHER = 'None'
+DEFAULT_ENCRYPT_KEY_ID = 'None'
+
+# Class of create options from the plugin configuration, and options of the
+# mkfs command, handled in the volume-plugin at the docker host, and tracked
+# in volume metadata.
+CLASS = 'class'
+DEFAULT_CLASS = 'None'
+MKFS_OPTIONS = 'mkfs-options'
+DEFAULT_MKFS_OPTIONS = 'None'
+
 # Create a kv store object for this volume identified by vol_path
 # Create the side car or open if it exists.
 def init():